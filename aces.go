@@ -0,0 +1,163 @@
+package exr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ACES image container files (SMPTE ST 2065-4) are uncompressed scanline images with half R, G, B and
+// optionally A channels in ACES AP0 primaries.  Stereo images have the views "left" and "right".
+
+// SetAdoptedNeutral sets the CIE x,y coordinates of the color which is displayed as neutral.
+func (h *Header) SetAdoptedNeutral(v V2f) {
+	h.adoptedNeutral = &v
+}
+
+// AdoptedNeutral returns the adoptedNeutral attribute, ok is false if the header doesn't have one.
+func (h *Header) AdoptedNeutral() (v V2f, ok bool) {
+	if h.adoptedNeutral == nil {
+		return V2f{}, false
+	}
+
+	return *h.adoptedNeutral, true
+}
+
+// SetACESContainerFlag sets whether the acesImageContainerFlag attribute is written, marking the file as
+// an ACES image container.
+func (h *Header) SetACESContainerFlag(flag bool) {
+	h.acesContainer = flag
+}
+
+// ACESContainerFlag returns true if the header has the acesImageContainerFlag attribute set.
+func (h *Header) ACESContainerFlag() bool {
+	return h.acesContainer
+}
+
+// ValidateACES checks that the header describes an ACES image container file, the returned error lists
+// every way in which it doesn't.
+func (h *Header) ValidateACES() error {
+	var reasons []string
+
+	fail := func(format string, a ...interface{}) {
+		reasons = append(reasons, fmt.Sprintf(format, a...))
+	}
+
+	white := V2f{ACESAP0Chromaticities.whiteX, ACESAP0Chromaticities.whiteY}
+
+	if !h.acesContainer {
+		fail("acesImageContainerFlag attribute is missing")
+	}
+
+	if h.adoptedNeutral == nil {
+		fail("adoptedNeutral attribute is missing")
+	} else if !closeV2f(*h.adoptedNeutral, white) {
+		fail("adoptedNeutral is %v, expected %v", *h.adoptedNeutral, white)
+	}
+
+	if h.chromaticities == nil {
+		fail("chromaticities attribute is missing")
+	} else if !closeChromaticities(*h.chromaticities, ACESAP0Chromaticities) {
+		fail("chromaticities are not ACES AP0")
+	}
+
+	if h.compression != CompressionTypeNone {
+		fail("compression is %v, expected none", h.compression)
+	}
+
+	if h.tiled {
+		fail("image is tiled")
+	}
+
+	if h.lineOrder != LineOrderIncreasingY && h.lineOrder != LineOrderDecreasingY {
+		fail("line order is %v, expected increasing or decreasing y", h.lineOrder)
+	}
+
+	views := []string{""}
+
+	if len(h.multiView) > 0 {
+		views = h.multiView
+
+		for _, view := range h.multiView {
+			if view != "left" && view != "right" {
+				fail("view %q is not left or right", view)
+			}
+		}
+	}
+
+	for _, ch := range h.channels {
+		view := ViewFromChannelName(ch.Name, h.multiView)
+		name := RemoveViewName(ch.Name, view)
+
+		if name != "R" && name != "G" && name != "B" && name != "A" {
+			fail("channel %v is not R, G, B or A", ch.Name)
+		}
+
+		if ch.PixelType != PixelTypeHalf {
+			fail("channel %v is not half", ch.Name)
+		}
+
+		if ch.XSampling != 1 || ch.YSampling != 1 {
+			fail("channel %v is sub-sampled", ch.Name)
+		}
+	}
+
+	for i, view := range views {
+		for _, name := range []string{"R", "G", "B"} {
+			// Channels of the default view may omit the view name
+			found := i == 0 && h.FindChannel(name) != nil
+
+			if view != "" && h.FindChannel(view+"."+name) != nil {
+				found = true
+			}
+
+			if found {
+				continue
+			}
+
+			if view == "" {
+				fail("channel %v is missing", name)
+			} else {
+				fail("channel %v is missing from view %v", name, view)
+			}
+		}
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("not an ACES image container: %v", strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// SetACESContainer makes the file an ACES image container.  The chromaticities, adoptedNeutral and
+// acesImageContainerFlag attributes are set, compression is removed and channels are stored as half.
+// WritePixels fails if the header is still not compliant, for example if it has channels other than
+// R, G, B and A.
+func (o *OutputFile) SetACESContainer() {
+	h := &o.header
+
+	h.SetChromaticities(ACESAP0Chromaticities)
+	h.SetAdoptedNeutral(V2f{ACESAP0Chromaticities.whiteX, ACESAP0Chromaticities.whiteY})
+	h.SetACESContainerFlag(true)
+	h.SetCompression(CompressionTypeNone)
+
+	h.channels = append([]Channel(nil), h.channels...)
+
+	for i := range h.channels {
+		h.channels[i].PixelType = PixelTypeHalf
+	}
+
+	o.aces = true
+}
+
+func closeV2f(a, b V2f) bool {
+	return math.Abs(float64(a[0]-b[0])) < 1e-4 && math.Abs(float64(a[1]-b[1])) < 1e-4
+}
+
+func closeChromaticities(a, b Chromaticities) bool {
+	return closeV2f(V2f{a.redX, a.redY}, V2f{b.redX, b.redY}) &&
+		closeV2f(V2f{a.greenX, a.greenY}, V2f{b.greenX, b.greenY}) &&
+		closeV2f(V2f{a.blueX, a.blueY}, V2f{b.blueX, b.blueY}) &&
+		closeV2f(V2f{a.whiteX, a.whiteY}, V2f{b.whiteX, b.whiteY})
+}
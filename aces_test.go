@@ -0,0 +1,94 @@
+package exr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestACESContainer(t *testing.T) {
+	h := NewHeader(4, 4)
+	h.SetCompression(CompressionTypeZip)
+
+	for _, name := range []string{"R", "G", "B", "A"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+	}
+
+	if err := h.ValidateACES(); err == nil {
+		t.Fatalf("expected plain header not to be ACES compliant")
+	}
+
+	data := make([]float32, 4*4*4)
+
+	var fb Framebuffer
+
+	for c, name := range []string{"R", "G", "B", "A"} {
+		InsertSlice(&fb, name, NewInterleavedSlice(data, 0, 0, 4, 4, c))
+	}
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+	of.SetACESContainer()
+
+	if err := of.WritePixels(4); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if ch := h.FindChannel("R"); ch.PixelType != PixelTypeFloat {
+		t.Fatalf("SetACESContainer modified the caller's header")
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	h = in.Header()
+
+	if err := h.ValidateACES(); err != nil {
+		t.Fatalf("expected written file to be ACES compliant: %v", err)
+	}
+
+	if h.Compression() != CompressionTypeNone || h.FindChannel("R").PixelType != PixelTypeHalf {
+		t.Fatalf("unexpected compression %v or pixel type %v", h.Compression(), h.FindChannel("R").PixelType)
+	}
+
+	// Stereo images use the left and right views
+	h.SetMultiView([]string{"left", "right"})
+
+	if err := h.ValidateACES(); err == nil || !strings.Contains(err.Error(), "missing from view right") {
+		t.Fatalf("expected missing right view channels, got %v", err)
+	}
+
+	for _, name := range []string{"right.R", "right.G", "right.B"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	}
+
+	if err := h.ValidateACES(); err != nil {
+		t.Fatalf("expected stereo header to be ACES compliant: %v", err)
+	}
+
+	h.AddChannel(Channel{Name: "Z", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	of = NewOutputFile(&writeSeekBuffer{}, h)
+	of.SetACESContainer()
+
+	if err := of.WritePixels(4); err == nil || !strings.Contains(err.Error(), "channel Z is not R, G, B or A") {
+		t.Fatalf("expected error writing Z channel, got %v", err)
+	}
+
+	// Decreasing y is valid for ACES but can't be written
+	h = NewHeader(4, 4)
+	h.SetLineOrder(LineOrderDecreasingY)
+	h.AddChannel(Channel{Name: "R", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+
+	of = NewOutputFile(&writeSeekBuffer{}, h)
+	of.SetFramebuffer(fb)
+	of.SetACESContainer()
+
+	if err := of.WritePixels(4); err == nil || !strings.Contains(err.Error(), "decreasing y") {
+		t.Fatalf("expected error writing decreasing y line order, got %v", err)
+	}
+}
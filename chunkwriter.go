@@ -0,0 +1,180 @@
+package exr
+
+import (
+	"runtime"
+	"sync"
+)
+
+// WriteOptions control how an OutputFile compresses and writes chunks.
+type WriteOptions struct {
+	Workers        int   // Goroutines used to compress chunks, if zero then runtime.GOMAXPROCS(0)
+	ChunksInFlight int   // Chunks being compressed or waiting to be written at once, if zero then twice Workers
+	MaxSpoolMemory int64 // Bytes of the file a StreamOutputFile keeps in memory, if zero then 64MB
+
+	// Progress is called after each chunk is written with the number of chunks written and the number of
+	// chunks in the image.  Calls are made one at a time while the file is locked so Progress mustn't call
+	// methods of the OutputFile.
+	Progress func(done, total int)
+}
+
+// workers returns the number of goroutines to compress chunks with.
+func (opts *WriteOptions) workers() int {
+	if opts == nil || opts.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return opts.Workers
+}
+
+// chunksInFlight returns the most chunks held in memory while compressing.
+func (opts *WriteOptions) chunksInFlight() int {
+	if opts == nil || opts.ChunksInFlight <= 0 {
+		return 2 * opts.workers()
+	}
+
+	return opts.ChunksInFlight
+}
+
+// progressFunc returns the progress callback of the options, nil if opts is nil.
+func (opts *WriteOptions) progressFunc() func(done, total int) {
+	if opts == nil {
+		return nil
+	}
+
+	return opts.Progress
+}
+
+// chunkJob is a chunk passed to the compression workers.
+type chunkJob struct {
+	chunk, y int
+	raw      []byte // Uncompressed pixel data
+	data     []byte // Compressed pixel data
+	err      error
+}
+
+// chunkWriter compresses chunks on a pool of goroutines and writes them to an OutputFile.  Chunks are
+// written in the order they were added unless the line order is random, then as soon as they're compressed.
+type chunkWriter struct {
+	o       *OutputFile
+	ordered bool
+	limit   int // Chunks added and not yet written at once
+
+	jobs     chan *chunkJob
+	finished chan *chunkJob
+	wg       sync.WaitGroup
+
+	pending int               // Chunks added and not yet written
+	done    map[int]*chunkJob // Compressed chunks waiting for earlier chunks to be written
+	next    int               // Next chunk to write when ordered, -1 before the first chunk is added
+	free    [][]byte          // Buffers of written chunks for reuse
+}
+
+// newChunkWriter starts the compression workers for the output file.
+func newChunkWriter(o *OutputFile, workers, limit int) *chunkWriter {
+	w := &chunkWriter{
+		o:        o,
+		ordered:  o.header.lineOrder != LineOrderRandomY,
+		limit:    limit,
+		jobs:     make(chan *chunkJob),
+		finished: make(chan *chunkJob, limit), // Never blocks as at most limit chunks are pending
+		done:     make(map[int]*chunkJob),
+		next:     -1,
+	}
+
+	for i := 0; i < workers; i++ {
+		w.wg.Add(1)
+
+		go func() {
+			defer w.wg.Done()
+
+			for job := range w.jobs {
+				job.data, job.err = compressChunk(o.header.compression, job.raw)
+				w.finished <- job
+			}
+		}()
+	}
+
+	return w
+}
+
+// buffer returns an empty buffer for the pixel data of the next chunk.
+func (w *chunkWriter) buffer() []byte {
+	if n := len(w.free); n > 0 {
+		buf := w.free[n-1]
+		w.free = w.free[:n-1]
+
+		return buf[:0]
+	}
+
+	return nil
+}
+
+// add passes the pixel data of a chunk to the workers, first writing compressed chunks until there is
+// room for another.  The chunk owns raw until it is written.
+func (w *chunkWriter) add(chunk, y int, raw []byte) error {
+	for w.pending >= w.limit {
+		if err := w.receive(); err != nil {
+			return err
+		}
+	}
+
+	if w.next < 0 {
+		w.next = chunk
+	}
+
+	w.pending++
+	w.jobs <- &chunkJob{chunk: chunk, y: y, raw: raw}
+
+	return nil
+}
+
+// receive waits for a chunk to be compressed and writes whichever chunks are ready.
+func (w *chunkWriter) receive() error {
+	job := <-w.finished
+
+	if !w.ordered {
+		return w.write(job)
+	}
+
+	w.done[job.chunk] = job
+
+	for {
+		job, ok := w.done[w.next]
+
+		if !ok {
+			return nil
+		}
+
+		delete(w.done, w.next)
+		w.next++
+
+		if err := w.write(job); err != nil {
+			return err
+		}
+	}
+}
+
+// write writes a compressed chunk to the file.
+func (w *chunkWriter) write(job *chunkJob) error {
+	w.pending--
+	w.free = append(w.free, job.raw)
+
+	if job.err != nil {
+		return job.err
+	}
+
+	return w.o.writeChunkData(job.chunk, job.y, job.data)
+}
+
+// close writes the remaining chunks unless err is not nil and stops the workers.
+func (w *chunkWriter) close(err error) error {
+	close(w.jobs)
+
+	for err == nil && w.pending > 0 {
+		err = w.receive()
+	}
+
+	w.wg.Wait()
+
+	return err
+}
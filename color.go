@@ -0,0 +1,232 @@
+package exr
+
+import (
+	"fmt"
+)
+
+// Matrices are stored row major and are applied to column vectors, i.e. XYZ = M * RGB.
+
+// Chromaticities of common RGB color spaces, see also Rec709Chromaticities.
+var (
+	Rec2020Chromaticities = NewChromaticities(V2f{0.7080, 0.2920}, V2f{0.1700, 0.7970}, V2f{0.1310, 0.0460}, V2f{0.3127, 0.3290})
+	DCIP3Chromaticities   = NewChromaticities(V2f{0.6800, 0.3200}, V2f{0.2650, 0.6900}, V2f{0.1500, 0.0600}, V2f{0.3140, 0.3510})
+	P3D65Chromaticities   = NewChromaticities(V2f{0.6800, 0.3200}, V2f{0.2650, 0.6900}, V2f{0.1500, 0.0600}, V2f{0.3127, 0.3290})
+	ACESAP0Chromaticities = NewChromaticities(V2f{0.7347, 0.2653}, V2f{0.0000, 1.0000}, V2f{0.0001, -0.0770}, V2f{0.32168, 0.33767})
+	ACESAP1Chromaticities = NewChromaticities(V2f{0.7130, 0.2930}, V2f{0.1650, 0.8300}, V2f{0.1280, 0.0440}, V2f{0.32168, 0.33767})
+)
+
+// RGBToXYZ returns the matrix which converts RGB with the given chromaticities to CIE XYZ, RGB (1,1,1)
+// maps to the white point with luminance y.
+func RGBToXYZ(c Chromaticities, y float32) M33f {
+	return toM33f(rgbToXYZ(c, float64(y)))
+}
+
+// XYZToRGB returns the matrix which converts CIE XYZ to RGB with the given chromaticities, the inverse of
+// RGBToXYZ.
+func XYZToRGB(c Chromaticities, y float32) M33f {
+	return toM33f(invert33(rgbToXYZ(c, float64(y))))
+}
+
+// bradford is the cone response matrix of the Bradford chromatic adaptation transform.
+var bradford = [9]float64{
+	0.8951, 0.2664, -0.1614,
+	-0.7502, 1.7135, 0.0367,
+	0.0389, -0.0685, 1.0296,
+}
+
+// BradfordAdaptation returns the matrix which converts XYZ colors seen under the src white point to those
+// which look the same under the dst white point.
+func BradfordAdaptation(src, dst V2f) M33f {
+	return toM33f(bradfordAdaptation(src, dst))
+}
+
+func bradfordAdaptation(src, dst V2f) [9]float64 {
+	whiteXYZ := func(w V2f) [3]float64 {
+		x, y := float64(w[0]), float64(w[1])
+		return [3]float64{x / y, 1, (1 - x - y) / y}
+	}
+
+	s := mul33v(bradford, whiteXYZ(src))
+	d := mul33v(bradford, whiteXYZ(dst))
+
+	scale := [9]float64{
+		d[0] / s[0], 0, 0,
+		0, d[1] / s[1], 0,
+		0, 0, d[2] / s[2],
+	}
+
+	return mul33(invert33(bradford), mul33(scale, bradford))
+}
+
+// conversionMatrix returns the matrix which converts RGB with the src chromaticities to RGB with the dst
+// chromaticities, adapting between the white points.
+func conversionMatrix(src, dst Chromaticities) [9]float64 {
+	m := rgbToXYZ(src, 1)
+
+	if src.whiteX != dst.whiteX || src.whiteY != dst.whiteY {
+		m = mul33(bradfordAdaptation(V2f{src.whiteX, src.whiteY}, V2f{dst.whiteX, dst.whiteY}), m)
+	}
+
+	return mul33(invert33(rgbToXYZ(dst, 1)), m)
+}
+
+// ConversionMatrix returns the matrix which converts RGB with the src chromaticities to RGB with the dst
+// chromaticities.  Bradford adaptation is used if the white points differ.
+func ConversionMatrix(src, dst Chromaticities) M33f {
+	return toM33f(conversionMatrix(src, dst))
+}
+
+// Apply returns M * (r, g, b).
+func (m M33f) Apply(r, g, b float32) (float32, float32, float32) {
+	return m[0]*r + m[1]*g + m[2]*b,
+		m[3]*r + m[4]*g + m[5]*b,
+		m[6]*r + m[7]*g + m[8]*b
+}
+
+// ConvertRGBA converts interleaved RGBA pixels, as used by LoadRGBA and SaveRGBA, from the src to the dst
+// primaries.  Alpha is unchanged.
+func ConvertRGBA(data []float32, src, dst Chromaticities) {
+	m := ConversionMatrix(src, dst)
+
+	for i := 0; i+3 < len(data); i += 4 {
+		data[i], data[i+1], data[i+2] = m.Apply(data[i], data[i+1], data[i+2])
+	}
+}
+
+// ConvertPrimaries converts the R, G and B channels of the given layer in the data window of h from the
+// src to the dst primaries.  The three channels must be in the framebuffer with the same sampling.
+func (fb *Framebuffer) ConvertPrimaries(h *Header, layer string, src, dst Chromaticities) error {
+	var rgb [3]*Pixels
+
+	for i, name := range []string{"R", "G", "B"} {
+		name = JoinChannelName(layer, name)
+
+		if rgb[i] = fb.find(name); rgb[i] == nil {
+			return fmt.Errorf("channel %v is not in framebuffer", name)
+		}
+	}
+
+	xs, ys := rgb[0].sampling()
+
+	for _, p := range rgb[1:] {
+		if pxs, pys := p.sampling(); pxs != xs || pys != ys {
+			return fmt.Errorf("R, G and B channels of layer %q have different sampling", layer)
+		}
+	}
+
+	m := ConversionMatrix(src, dst)
+
+	xMin, yMin, xMax, yMax := h.DataWindow()
+
+	for y := int(yMin) + mod(-int(yMin), ys); y <= int(yMax); y += ys {
+		for x := int(xMin) + mod(-int(xMin), xs); x <= int(xMax); x += xs {
+			var v [3]float32
+
+			for i, p := range rgb {
+				var err error
+
+				if v[i], err = p.at(x, y); err != nil {
+					return err
+				}
+			}
+
+			v[0], v[1], v[2] = m.Apply(v[0], v[1], v[2])
+
+			for i, p := range rgb {
+				if err := p.set(x, y, v[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// rgbToXYZ returns the matrix which converts RGB with the given chromaticities to CIE XYZ.  RGB (1,1,1)
+// maps to the white point with luminance y.
+func rgbToXYZ(c Chromaticities, y float64) [9]float64 {
+	// Columns are the XYZ of each primary with unit luminance scaled by 1/y, (x, y, 1-x-y)/y.
+	p := [9]float64{
+		float64(c.redX), float64(c.greenX), float64(c.blueX),
+		float64(c.redY), float64(c.greenY), float64(c.blueY),
+		float64(1 - c.redX - c.redY), float64(1 - c.greenX - c.greenY), float64(1 - c.blueX - c.blueY),
+	}
+
+	white := [3]float64{
+		float64(c.whiteX) * y / float64(c.whiteY),
+		y,
+		float64(1-c.whiteX-c.whiteY) * y / float64(c.whiteY),
+	}
+
+	// Scale each primary so that the sum of them is the white point
+	s := mul33v(invert33(p), white)
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			p[i*3+j] *= s[j]
+		}
+	}
+
+	return p
+}
+
+// luminanceWeights returns the weights used to compute luminance from RGB with the given chromaticities.
+func luminanceWeights(c Chromaticities) [3]float32 {
+	m := rgbToXYZ(c, 1)
+
+	sum := m[3] + m[4] + m[5]
+
+	return [3]float32{float32(m[3] / sum), float32(m[4] / sum), float32(m[5] / sum)}
+}
+
+// invert33 returns the inverse of m, singular matrices return the zero matrix.
+func invert33(m [9]float64) [9]float64 {
+	c := [9]float64{
+		m[4]*m[8] - m[5]*m[7], m[2]*m[7] - m[1]*m[8], m[1]*m[5] - m[2]*m[4],
+		m[5]*m[6] - m[3]*m[8], m[0]*m[8] - m[2]*m[6], m[2]*m[3] - m[0]*m[5],
+		m[3]*m[7] - m[4]*m[6], m[1]*m[6] - m[0]*m[7], m[0]*m[4] - m[1]*m[3],
+	}
+
+	det := m[0]*c[0] + m[1]*c[3] + m[2]*c[6]
+
+	if det == 0 {
+		return [9]float64{}
+	}
+
+	for i := range c {
+		c[i] /= det
+	}
+
+	return c
+}
+
+func mul33v(m [9]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+}
+
+func mul33(a, b [9]float64) [9]float64 {
+	var m [9]float64
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i*3+j] = a[i*3]*b[j] + a[i*3+1]*b[3+j] + a[i*3+2]*b[6+j]
+		}
+	}
+
+	return m
+}
+
+func toM33f(m [9]float64) M33f {
+	var f M33f
+
+	for i := range m {
+		f[i] = float32(m[i])
+	}
+
+	return f
+}
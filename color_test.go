@@ -0,0 +1,119 @@
+package exr
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func checkMatrix(t *testing.T, name string, m M33f, expected [9]float32, tolerance float64) {
+	t.Helper()
+
+	for i := range m {
+		if math.Abs(float64(m[i]-expected[i])) > tolerance {
+			t.Fatalf("%v: %v does not match %v", name, m, expected)
+		}
+	}
+}
+
+func TestColorMatrices(t *testing.T) {
+	checkMatrix(t, "Rec709 RGBToXYZ", RGBToXYZ(Rec709Chromaticities, 1), [9]float32{
+		0.4124, 0.3576, 0.1805,
+		0.2126, 0.7152, 0.0722,
+		0.0193, 0.1192, 0.9505,
+	}, 1e-3)
+
+	checkMatrix(t, "Rec709 to AP0", ConversionMatrix(Rec709Chromaticities, ACESAP0Chromaticities), [9]float32{
+		0.4397, 0.3830, 0.1773,
+		0.0898, 0.8134, 0.0968,
+		0.0175, 0.1116, 0.8709,
+	}, 2e-3)
+
+	checkMatrix(t, "AP0 to AP1", ConversionMatrix(ACESAP0Chromaticities, ACESAP1Chromaticities), [9]float32{
+		1.4514, -0.2365, -0.2149,
+		-0.0766, 1.1762, -0.0997,
+		0.0083, -0.0060, 0.9977,
+	}, 2e-3)
+
+	all := []Chromaticities{Rec709Chromaticities, Rec2020Chromaticities, DCIP3Chromaticities, P3D65Chromaticities,
+		ACESAP0Chromaticities, ACESAP1Chromaticities}
+
+	// White is preserved and conversions invert
+	for _, src := range all {
+		for _, dst := range all {
+			m := ConversionMatrix(src, dst)
+
+			r, g, b := m.Apply(1, 1, 1)
+
+			checkMatrix(t, "white", M33f{r, g, b}, [9]float32{1, 1, 1}, 1e-4)
+
+			r, g, b = ConversionMatrix(dst, src).Apply(m.Apply(0.1, 0.5, 0.9))
+
+			checkMatrix(t, "round trip", M33f{r, g, b}, [9]float32{0.1, 0.5, 0.9}, 1e-4)
+		}
+	}
+
+	rgba := []float32{1, 1, 1, 0.5}
+
+	ConvertRGBA(rgba, Rec709Chromaticities, ACESAP0Chromaticities)
+
+	checkMatrix(t, "ConvertRGBA", M33f{rgba[0], rgba[1], rgba[2], rgba[3]}, [9]float32{1, 1, 1, 0.5}, 1e-4)
+}
+
+func TestConvertPrimaries(t *testing.T) {
+	h := NewHeaderWindow(1, 1, 4, 2)
+
+	data := make([]Half, 4*2*3)
+
+	for i := range data {
+		data[i] = Half(Float32ToFloat16(float32(i%7) / 6))
+	}
+
+	var fb Framebuffer
+
+	for c, name := range []string{"R", "G", "B"} {
+		fb.InsertLayer("diffuse", name, NewInterleavedSlice(data, 1, 1, 4, 3, c).Pixels())
+	}
+
+	expected := make([]float32, len(data))
+
+	for i := range data {
+		expected[i] = Float16ToFloat32(Float16(data[i]))
+	}
+
+	if err := fb.ConvertPrimaries(&h, "diffuse", Rec709Chromaticities, Rec2020Chromaticities); err != nil {
+		t.Fatalf("error converting primaries: %v", err)
+	}
+
+	if err := fb.ConvertPrimaries(&h, "diffuse", Rec2020Chromaticities, Rec709Chromaticities); err != nil {
+		t.Fatalf("error converting primaries: %v", err)
+	}
+
+	for i := range data {
+		if d := Float16ToFloat32(Float16(data[i])) - expected[i]; d < -2e-3 || d > 2e-3 {
+			t.Fatalf("sample %v is %v after round trip, expected %v", i, Float16ToFloat32(Float16(data[i])), expected[i])
+		}
+	}
+
+	if err := fb.ConvertPrimaries(&h, "specular", Rec709Chromaticities, Rec2020Chromaticities); err == nil {
+		t.Fatalf("expected error converting missing layer")
+	}
+}
+
+func TestSaveChromaticities(t *testing.T) {
+	ws := &writeSeekBuffer{}
+
+	if err := SaveRGBA(ws, 2, 2, make([]float32, 16), &SaveOptions{PixelType: PixelTypeHalf, Chromaticities: &ACESAP0Chromaticities}); err != nil {
+		t.Fatalf("error saving image: %v", err)
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	if h := in.Header(); h.Chromaticities() != ACESAP0Chromaticities {
+		t.Fatalf("chromaticities %v do not match %v", h.Chromaticities(), ACESAP0Chromaticities)
+	}
+}
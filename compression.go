@@ -0,0 +1,75 @@
+package exr
+
+import (
+	"fmt"
+)
+
+// linesPerChunk returns the number of scan lines in a block for the given compression.
+func linesPerChunk(compression int) int {
+	switch compression {
+	case CompressionTypeZip, CompressionTypePXR24:
+		return 16
+	case CompressionTypePiz, CompresstionTypeB44, CompressionTypeB44A:
+		return 32
+	}
+
+	// NO_COMPRESSION, RLE_COMPRESSION and ZIPS_COMPRESSION
+	return 1
+}
+
+// supportedCompression returns true if chunks with the given compression can be read and written.
+func supportedCompression(compression int) bool {
+	switch compression {
+	case CompressionTypeNone, CompressionTypeRLE, CompressionTypeZipS, CompressionTypeZip:
+		return true
+	}
+
+	return false
+}
+
+// compressChunk compresses the raw pixel data of a chunk.  If the compressed data would be larger than
+// the raw data then the raw data is returned, as the spec requires.
+func compressChunk(compression int, raw []byte) ([]byte, error) {
+	switch compression {
+	case CompressionTypeNone:
+		return raw, nil
+	case CompressionTypeRLE:
+		return rleEncode(raw), nil
+	case CompressionTypeZipS, CompressionTypeZip:
+		return zipEncode(raw)
+	}
+
+	return nil, fmt.Errorf("%w (%v)", ErrUnsupportedCompression, compression)
+}
+
+// decompressChunk expands the pixel data of a chunk which should be size bytes once decompressed.
+func decompressChunk(compression int, data []byte, size int) ([]byte, error) {
+	if len(data) == size {
+		// Data was stored uncompressed
+		return data, nil
+	}
+
+	var out []byte
+	var err error
+
+	switch compression {
+	case CompressionTypeNone:
+		return nil, fmt.Errorf("%w: uncompressed chunk has size %v, expected %v", ErrCorruptChunk, len(data), size)
+	case CompressionTypeRLE:
+		out, err = rleDecode(data, size)
+	case CompressionTypeZipS, CompressionTypeZip:
+		out, err = zipDecode(data, size)
+	default:
+		return nil, fmt.Errorf("%w (%v)", ErrUnsupportedCompression, compression)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: decompressing chunk: %v", ErrCorruptChunk, err)
+	}
+
+	if len(out) != size {
+		return nil, fmt.Errorf("%w: decompressed chunk has size %v, expected %v", ErrCorruptChunk, len(out), size)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package exr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"io"
+)
+
+// magic is the start of every EXR file, as checked by ReadVersion.
+const magic = "\x76\x2f\x31\x01"
+
+func init() {
+	image.RegisterFormat("exr", magic, Decode, DecodeConfig)
+}
+
+// Decode reads an EXR image from r and returns it as a *FloatImage with bounds equal to the data window.
+// Pixel values are linear and unclamped, At clamps them to 16 bits.  If scanlines are missing from the
+// file then the image is returned along with an *IncompleteError.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeWithOptions is like Decode but reads the file with the given options, if opts is nil then
+// DefaultLimits apply.
+func DecodeWithOptions(r io.Reader, opts *ReadOptions) (image.Image, error) {
+	return DecodeContext(context.Background(), r, opts)
+}
+
+// DecodeContext is like DecodeWithOptions but stops between chunks once ctx is cancelled, returning
+// ctx.Err().
+func DecodeContext(ctx context.Context, r io.Reader, opts *ReadOptions) (image.Image, error) {
+	rs, err := readSeeker(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := NewInputFileOptions(rs, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := newRGBAInputFile(file, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := in.Header()
+	xMin, yMin, xMax, yMax := h.DataWindow()
+
+	img := NewFloatImage(image.Rect(int(xMin), int(yMin), int(xMax)+1, int(yMax)+1))
+
+	in.SetFramebuffer(img.Pix, -(xMin*4 + yMin*int32(img.Stride)), 4, int32(img.Stride))
+
+	if err := in.ReadPixelsContext(ctx, int(yMin), int(yMax)); err != nil {
+		if _, ok := err.(*IncompleteError); ok {
+			return img, err
+		}
+
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// DecodeConfig returns the colour model and the size of the data window of an EXR image without
+// reading the pixels.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	_, h, err := readFileHeader(bufio.NewReader(r), DefaultLimits)
+
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	// Decode only reads scanline images
+	if h.tiled {
+		return image.Config{}, fmt.Errorf("tiled images can't be decoded")
+	}
+
+	xMin, yMin, xMax, yMax := h.DataWindow()
+
+	return image.Config{
+		ColorModel: FloatColorModel,
+		Width:      int(xMax - xMin + 1),
+		Height:     int(yMax - yMin + 1),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package exr
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	f, err := os.Open("testdata/asakusa.exr")
+
+	if err != nil {
+		t.Fatalf("error loading testdata: %v", err)
+	}
+
+	defer f.Close()
+
+	img, format, err := image.Decode(f)
+
+	if err != nil {
+		t.Fatalf("error decoding image: %v", err)
+	}
+
+	if format != "exr" {
+		t.Fatalf("expected format exr, got %v", format)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 660, 440) {
+		t.Fatalf("expected bounds (0,0)-(660,440), got %v", img.Bounds())
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("error seeking testdata: %v", err)
+	}
+
+	cfg, format, err := image.DecodeConfig(f)
+
+	if err != nil {
+		t.Fatalf("error decoding config: %v", err)
+	}
+
+	if format != "exr" || cfg.Width != 660 || cfg.Height != 440 {
+		t.Fatalf("expected exr 660x440, got %v %vx%v", format, cfg.Width, cfg.Height)
+	}
+}
+
+func TestDecodeWindow(t *testing.T) {
+	h := NewHeaderWindow(-2, 3, 5, 8)
+	h.AddChannel(Channel{Name: "R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	r := make([]float32, 8*6)
+
+	for i := range r {
+		r[i] = float32(i) / 10
+	}
+
+	fb := Framebuffer{}
+	fb.Insert("R", Pixels{PixelTypeFloat, r, -(-2 + 3*8), 1, 8, 1, 1, 0})
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(6); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	img, err := Decode(bytes.NewBuffer(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error decoding image: %v", err)
+	}
+
+	if img.Bounds() != image.Rect(-2, 3, 6, 9) {
+		t.Fatalf("expected bounds (-2,3)-(6,9), got %v", img.Bounds())
+	}
+
+	// Pixel (-1, 4) is the ninth value, 0.9 clamps to 16 bits and missing alpha is opaque
+	expected := color.RGBA64{R: 0xe666, A: 0xffff}
+
+	if c := color.RGBA64Model.Convert(img.At(-1, 4)); c != expected {
+		t.Fatalf("expected %v, got %v", expected, c)
+	}
+
+	if c := img.At(5, 8).(FloatColor); c.R != float32(47)/10 {
+		t.Fatalf("expected unclamped value 4.7, got %v", c.R)
+	}
+}
+
+func TestDecodeTiled(t *testing.T) {
+	channels, err := (&Chlist{{Name: "Y", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1}}).MarshalBinary()
+
+	if err != nil {
+		t.Fatalf("error marshalling channels: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+
+	WriteVersion(&EXRVersion{tiled: true}, w)
+	WriteAttrib(&EXRAttribute{name: "channels", attribType: "chlist", value: channels}, w)
+	WriteAttrib(&EXRAttribute{name: "dataWindow", attribType: "box2i", value: make([]byte, 16)}, w)
+	WriteAttrib(&EXRAttribute{name: "tiles", attribType: "tiledesc", value: []byte{1, 0, 0, 0, 1, 0, 0, 0, 0}}, w)
+	WriteAttrib(nil, w)
+	w.Flush()
+
+	// Tiled images can't be decoded so their config isn't reported either
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("expected error decoding tiled image")
+	}
+
+	if _, err := DecodeConfig(bytes.NewReader(buf.Bytes())); err == nil || !strings.Contains(err.Error(), "tiled images can't be decoded") {
+		t.Fatalf("expected tiled image error, got %v", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := SaveRGBA(buf, 4, 4, make([]float32, 4*4*4), &SaveOptions{PixelType: PixelTypeHalf, Channels: WriteYCA}); err != nil {
+		f.Fatalf("error writing seed: %v", err)
+	}
+
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+
+		// Keep the image allocated by the test small
+		if err != nil || cfg.Width*cfg.Height > 1<<12 {
+			return
+		}
+
+		Decode(bytes.NewReader(data))
+	})
+}
+
+func TestFloatColorRGBA(t *testing.T) {
+	// HDR values brighter than alpha are clamped to it
+	c := FloatColor{4, 0.25, -1, 0.5}
+
+	r, g, b, a := c.RGBA()
+
+	if a != 0x8000 || r != a || g != 0x4000 || b != 0 {
+		t.Fatalf("expected (8000, 4000, 0, 8000), got (%x, %x, %x, %x)", r, g, b, a)
+	}
+
+	if n := color.NRGBAModel.Convert(c).(color.NRGBA); n != (color.NRGBA{255, 127, 0, 128}) {
+		t.Fatalf("expected NRGBA {255 127 0 128}, got %v", n)
+	}
+}
@@ -0,0 +1,131 @@
+package exr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// EncodeOptions controls how Encode writes an image.
+type EncodeOptions struct {
+	PixelType   int32 // PixelTypeHalf or PixelTypeFloat, if zero then PixelTypeHalf
+	Compression int   // One of CompressionTypeNone...
+
+	// Transfer converts a non-linear component in [0,1] to a linear value.  If nil SRGBToLinear is used.
+	// It is not applied to alpha or to the pixels of a FloatImage, which are already linear.
+	Transfer func(v float32) float32
+}
+
+// SRGBToLinear is the sRGB transfer function (IEC 61966-2-1) converting an encoded value to linear.
+func SRGBToLinear(v float32) float32 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return float32(math.Pow((float64(v)+0.055)/1.055, 2.4))
+}
+
+// LinearTransfer leaves values unchanged, for images which are already linear.
+func LinearTransfer(v float32) float32 {
+	return v
+}
+
+// Encode writes img as a scanline EXR image with a data window equal to the image bounds.  Non-linear
+// components are converted to linear with the transfer function and alpha is premultiplied.  Greyscale
+// images are written as luminance.  If opts is nil then half pixels with ZIP compression are written
+// using the sRGB transfer function.
+func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
+	if opts == nil {
+		opts = &EncodeOptions{PixelType: PixelTypeHalf, Compression: CompressionTypeZip}
+	}
+
+	transfer := opts.Transfer
+
+	if transfer == nil {
+		transfer = SRGBToLinear
+	}
+
+	b := img.Bounds()
+
+	if b.Empty() {
+		return fmt.Errorf("image has no pixels")
+	}
+
+	data := make([]float32, b.Dx()*b.Dy()*4)
+
+	// store converts non-premultiplied, non-linear components to linear premultiplied values.
+	store := func(i int, r, g, b, a float32) {
+		data[i] = transfer(r) * a
+		data[i+1] = transfer(g) * a
+		data[i+2] = transfer(b) * a
+		data[i+3] = a
+	}
+
+	i := 0
+
+	switch t := img.(type) {
+	case *FloatImage:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			ofs := t.PixOffset(b.Min.X, y)
+			i += copy(data[i:], t.Pix[ofs:ofs+b.Dx()*4])
+		}
+	case *image.NRGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := t.NRGBAAt(x, y)
+				store(i, float32(c.R)/0xff, float32(c.G)/0xff, float32(c.B)/0xff, float32(c.A)/0xff)
+				i += 4
+			}
+		}
+	case *image.NRGBA64:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := t.NRGBA64At(x, y)
+				store(i, float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff, float32(c.A)/0xffff)
+				i += 4
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, a := img.At(x, y).RGBA()
+
+				if a == 0 {
+					i += 4
+					continue
+				}
+
+				// Un-premultiply before applying the transfer function
+				af := float32(a)
+				store(i, float32(r)/af, float32(g)/af, float32(bl)/af, af/0xffff)
+				i += 4
+			}
+		}
+	}
+
+	channels := WriteRGBA
+
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		channels = WriteY
+	}
+
+	if opaque(data) {
+		channels &^= WriteA
+	} else {
+		channels |= WriteA
+	}
+
+	h := NewHeaderWindow(int32(b.Min.X), int32(b.Min.Y), int32(b.Max.X-1), int32(b.Max.Y-1))
+	h.SetCompression(opts.Compression)
+
+	pixelType := opts.PixelType
+
+	if pixelType == PixelTypeUInt {
+		pixelType = PixelTypeHalf
+	}
+
+	return writeRGBA(w, h, data, pixelType, channels)
+}
@@ -0,0 +1,90 @@
+package exr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	nrgba.SetNRGBA(1, 1, color.NRGBA{R: 255, G: 128, B: 0, A: 128})
+
+	gray := image.NewGray16(image.Rect(10, 20, 14, 24))
+	gray.SetGray16(11, 21, color.Gray16{Y: 0xffff})
+
+	float := NewFloatImage(image.Rect(0, 0, 2, 2))
+	float.SetFloat(1, 0, FloatColor{R: 10, G: -1, B: 0.5, A: 1})
+
+	testCases := []struct {
+		name     string
+		img      image.Image
+		x, y     int
+		expected FloatColor
+		channels RGBAChannels
+	}{
+		// sRGB 128 is 0.2158 linear, premultiplied by alpha 128/255
+		{"nrgba", nrgba, 1, 1, FloatColor{0.50196, 0.21586 * 0.50196, 0, 0.50196}, WriteRGBA},
+		{"gray16", gray, 11, 21, FloatColor{1, 1, 1, 1}, WriteY},
+		{"float", float, 1, 0, FloatColor{10, -1, 0.5, 1}, WriteRGBA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			if err := Encode(buf, tc.img, &EncodeOptions{PixelType: PixelTypeFloat}); err != nil {
+				t.Fatalf("error encoding image: %v", err)
+			}
+
+			in, err := NewRGBAInputFile(bytes.NewReader(buf.Bytes()))
+
+			if err != nil {
+				t.Fatalf("error reading image: %v", err)
+			}
+
+			if in.Channels() != tc.channels {
+				t.Fatalf("expected channels %x, got %x", tc.channels, in.Channels())
+			}
+
+			img, err := Decode(buf)
+
+			if err != nil {
+				t.Fatalf("error decoding image: %v", err)
+			}
+
+			if img.Bounds() != tc.img.Bounds() {
+				t.Fatalf("expected bounds %v, got %v", tc.img.Bounds(), img.Bounds())
+			}
+
+			c := img.(*FloatImage).FloatAt(tc.x, tc.y)
+
+			for i, v := range []float32{c.R, c.G, c.B, c.A} {
+				e := []float32{tc.expected.R, tc.expected.G, tc.expected.B, tc.expected.A}[i]
+
+				if math.Abs(float64(v-e)) > 1e-4 {
+					t.Fatalf("expected %v, got %v", tc.expected, c)
+				}
+			}
+		})
+	}
+
+	// A zero pixel type is half
+	buf := &bytes.Buffer{}
+
+	if err := Encode(buf, nrgba, &EncodeOptions{Compression: CompressionTypeZip}); err != nil {
+		t.Fatalf("error encoding image with zero pixel type: %v", err)
+	}
+
+	in, err := NewRGBAInputFile(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+
+	if h := in.Header(); h.FindChannel("R").PixelType != PixelTypeHalf {
+		t.Fatalf("expected half pixels, got %v", h.FindChannel("R").PixelType)
+	}
+}
@@ -0,0 +1,40 @@
+package exr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned when reading files, they are wrapped with more detail so use errors.Is to test for them.
+var (
+	ErrNotEXR                 = errors.New("exr: not an EXR file")
+	ErrUnsupportedVersion     = errors.New("exr: unsupported version")
+	ErrUnsupportedCompression = errors.New("exr: unsupported compression")
+	ErrCorruptChunk           = errors.New("exr: corrupt chunk")
+	ErrTruncated              = errors.New("exr: file is truncated")
+)
+
+// ChunkError is returned when a chunk of pixel data can't be read, Err wraps ErrCorruptChunk or
+// ErrTruncated.
+type ChunkError struct {
+	Chunk int // Index of the chunk in the offset table
+	Err   error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %v: %v", e.Chunk, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// truncated wraps errors caused by reaching the end of the file early with ErrTruncated.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrTruncated, err)
+	}
+
+	return err
+}
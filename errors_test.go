@@ -0,0 +1,80 @@
+package exr
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	h := NewHeader(8, 8)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+
+	ws := &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, h).WritePixels(8); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	file := func(modify func(b []byte) []byte) []byte {
+		return modify(append([]byte(nil), ws.buf...))
+	}
+
+	readPixels := func(b []byte) error {
+		in, err := NewInputFile(bytes.NewReader(b))
+
+		if err != nil {
+			return err
+		}
+
+		fb, err := AllocateFramebuffer[Half](&h, LayoutPlanar)
+
+		if err != nil {
+			t.Fatalf("error allocating framebuffer: %v", err)
+		}
+
+		in.SetFramebuffer(fb.Framebuffer)
+
+		return in.ReadPixels(0, 7)
+	}
+
+	// Chunks are one scanline of 8 half pixels following an 8 byte chunk header
+	lastChunk := len(ws.buf) - 8 - 16
+
+	for _, test := range []struct {
+		name  string
+		data  []byte
+		err   error
+		chunk int
+	}{
+		{"not exr", []byte("P6\n8 8\n255\n"), ErrNotEXR, -1},
+		{"version", file(func(b []byte) []byte { b[4] = 3; return b }), ErrUnsupportedVersion, -1},
+		{"multi-part", file(func(b []byte) []byte { b[5] |= 1 << 4; return b }), ErrUnsupportedVersion, -1},
+		{"truncated header", file(func(b []byte) []byte { return b[:40] }), ErrTruncated, -1},
+		{"compression", file(func(b []byte) []byte {
+			i := bytes.Index(b, []byte("compression\x00compression\x00"))
+			b[i+len("compression\x00compression\x00")+4] = CompressionTypePiz
+			return b
+		}), ErrUnsupportedCompression, -1},
+		{"chunk y", file(func(b []byte) []byte { b[lastChunk] = 0; return b }), ErrCorruptChunk, 7},
+		{"chunk size", file(func(b []byte) []byte { b[lastChunk+4] = 0xff; return b }), ErrCorruptChunk, 7},
+		{"truncated chunk", file(func(b []byte) []byte { return b[:len(b)-1] }), ErrTruncated, 7},
+	} {
+		err := readPixels(test.data)
+
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%v: expected %v, got %v", test.name, test.err, err)
+		}
+
+		var chunkErr *ChunkError
+
+		if errors.As(err, &chunkErr) != (test.chunk >= 0) || (chunkErr != nil && chunkErr.Chunk != test.chunk) {
+			t.Fatalf("%v: expected error for chunk %v, got %v", test.name, test.chunk, err)
+		}
+
+		// DecodeConfig rejects the same headers as Decode
+		if _, err := DecodeConfig(bytes.NewReader(test.data)); test.chunk < 0 && !errors.Is(err, test.err) {
+			t.Fatalf("%v: expected %v from DecodeConfig, got %v", test.name, test.err, err)
+		}
+	}
+}
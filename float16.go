@@ -36,9 +36,9 @@ func Float32ToFloat16(xe float32) Float16 {
 		return Float16(0xFE00) // NaN, only 1st mantissa bit set
 
 	} else { // Normalized number
-		hs := Float16(xs >> 16)                // Sign bit
-		hes := (uint32(xexp >> 23)) - 127 + 15 // Exponent unbias the single, then bias the halfp
-		if hes >= 0x1F {                       // Overflow
+		hs := Float16(xs >> 16)           // Sign bit
+		hes := int32(xexp>>23) - 127 + 15 // Exponent unbias the single, then bias the halfp
+		if hes >= 0x1F {                  // Overflow
 			return Float16((xs >> 16) | 0x7C00) // Signed Inf
 		} else if hes <= 0 { // Underflow
 
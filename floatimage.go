@@ -0,0 +1,106 @@
+package exr
+
+import (
+	"image"
+	"image/color"
+)
+
+// FloatColor is a linear, alpha-premultiplied colour with float32 components where 1 is full intensity.
+type FloatColor struct {
+	R, G, B, A float32
+}
+
+// clamp16 converts v to a 16-bit component, values outside [0,1] are clamped.
+func clamp16(v float32) uint32 {
+	if !(v > 0) {
+		return 0
+	}
+
+	if v >= 1 {
+		return 0xffff
+	}
+
+	return uint32(v*0xffff + 0.5)
+}
+
+// RGBA implements color.Color, components are clamped to 16 bits and the colour components to alpha, as
+// premultiplied colours must not be brighter than their alpha.
+func (c FloatColor) RGBA() (r, g, b, a uint32) {
+	a = clamp16(c.A)
+
+	return min(clamp16(c.R), a), min(clamp16(c.G), a), min(clamp16(c.B), a), a
+}
+
+// FloatColorModel converts any colour to a FloatColor.
+var FloatColorModel = color.ModelFunc(floatColorModel)
+
+func floatColorModel(c color.Color) color.Color {
+	if c, ok := c.(FloatColor); ok {
+		return c
+	}
+
+	r, g, b, a := c.RGBA()
+
+	return FloatColor{float32(r) / 0xffff, float32(g) / 0xffff, float32(b) / 0xffff, float32(a) / 0xffff}
+}
+
+// FloatImage is an in-memory image of FloatColor values.  The components of pixel (x, y) start at
+// Pix[(y-Rect.Min.Y)*Stride+(x-Rect.Min.X)*4] in the order R, G, B, A.
+type FloatImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewFloatImage returns a new FloatImage with the given bounds.
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+func (p *FloatImage) ColorModel() color.Model {
+	return FloatColorModel
+}
+
+func (p *FloatImage) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+func (p *FloatImage) At(x, y int) color.Color {
+	return p.FloatAt(x, y)
+}
+
+// FloatAt returns the colour of pixel (x, y) without clamping.
+func (p *FloatImage) FloatAt(x, y int) FloatColor {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return FloatColor{}
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+
+	return FloatColor{s[0], s[1], s[2], s[3]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to the pixel at (x, y).
+func (p *FloatImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+func (p *FloatImage) Set(x, y int, c color.Color) {
+	p.SetFloat(x, y, FloatColorModel.Convert(c).(FloatColor))
+}
+
+// SetFloat sets the colour of pixel (x, y).
+func (p *FloatImage) SetFloat(x, y int, c FloatColor) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+	s[0], s[1], s[2], s[3] = c.R, c.G, c.B, c.A
+}
@@ -66,6 +66,13 @@ const (
 
 const EXRVersionSize = 8
 
+// Attribute, type and channel names are limited to 31 bytes unless the long name flag is set in the
+// version, in which case they can be up to 255 bytes.
+const (
+	maxShortNameLength = 31
+	maxLongNameLength  = 255
+)
+
 type EXRVersion struct {
 	version   int  // this must be 2
 	tiled     bool // tile format image
@@ -3,17 +3,20 @@ package exr
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"sync"
 )
 
 type Pixels struct {
 	Kind                 int // One of PixelTypeUint...
 	Data                 interface{}
-	Base                 int32 // Pixel is found at Data[Base+x*XStride+y*YStride]
+	Base                 int32 // Pixel is found at Data[Base+(x/XSampling)*XStride+(y/YSampling)*YStride]
 	XStride, YStride     int32
 	XSampling, YSampling int // only for sub-sampled images
 	FillValue            float64
@@ -25,11 +28,22 @@ type TileDescription struct {
 }
 
 type Header struct {
-	dataWindow      [4]int32
-	displayWindow   [4]int32
-	channels        []Channel
-	tiled           bool
-	tileDescription TileDescription
+	dataWindow         [4]int32
+	displayWindow      [4]int32
+	pixelAspectRatio   float32
+	screenWindowCenter V2f
+	screenWindowWidth  float32
+	channels           []Channel
+	compression        int
+	lineOrder          int
+	chromaticities     *Chromaticities
+	preview            *Preview
+	adoptedNeutral     *V2f
+	acesContainer      bool // acesImageContainerFlag attribute
+	multiView          []string
+	view               string
+	tiled              bool
+	tileDescription    TileDescription
 }
 
 func NewHeader(width, height int) Header {
@@ -38,8 +52,10 @@ func NewHeader(width, height int) Header {
 
 func NewHeaderWindow(xMin, yMin, xMax, yMax int32) Header {
 	return Header{
-		dataWindow:    [4]int32{xMin, yMin, xMax, yMax},
-		displayWindow: [4]int32{xMin, yMin, xMax, yMax},
+		dataWindow:        [4]int32{xMin, yMin, xMax, yMax},
+		displayWindow:     [4]int32{xMin, yMin, xMax, yMax},
+		pixelAspectRatio:  1,
+		screenWindowWidth: 1,
 	}
 
 }
@@ -47,8 +63,13 @@ func NewHeaderWindow(xMin, yMin, xMax, yMax int32) Header {
 // Deep etc.
 func (h *Header) SetType() {}
 
+// AddChannel adds a channel to the header, channels are kept in alphabetical order as required by the file.
 func (h *Header) AddChannel(ch Channel) {
-	h.channels = append(h.channels, ch)
+	i := sort.Search(len(h.channels), func(i int) bool { return h.channels[i].Name >= ch.Name })
+
+	h.channels = append(h.channels, Channel{})
+	copy(h.channels[i+1:], h.channels[i:])
+	h.channels[i] = ch
 }
 
 func (h *Header) FindChannel(name string) *Channel {
@@ -61,11 +82,284 @@ func (h *Header) FindChannel(name string) *Channel {
 	return nil
 }
 
+// Channels returns the channels in the header in alphabetical order.
+func (h *Header) Channels() []Channel {
+	return append([]Channel(nil), h.channels...)
+}
+
+// DataWindow returns the bounds of the pixels stored in the file (inclusive).
+func (h *Header) DataWindow() (xMin, yMin, xMax, yMax int32) {
+	return h.dataWindow[0], h.dataWindow[1], h.dataWindow[2], h.dataWindow[3]
+}
+
+// DisplayWindow returns the bounds of the image to be displayed (inclusive).
+func (h *Header) DisplayWindow() (xMin, yMin, xMax, yMax int32) {
+	return h.displayWindow[0], h.displayWindow[1], h.displayWindow[2], h.displayWindow[3]
+}
+
+// SetCompression sets the compression, one of CompressionTypeNone...
+func (h *Header) SetCompression(compression int) {
+	h.compression = compression
+}
+
+func (h *Header) Compression() int {
+	return h.compression
+}
+
+// SetLineOrder sets the order chunks are stored in, one of LineOrderIncreasingY, LineOrderDecreasingY or
+// LineOrderRandomY.  An OutputFile can't write LineOrderDecreasingY.
+func (h *Header) SetLineOrder(lineOrder int) {
+	h.lineOrder = lineOrder
+}
+
+// LineOrder returns the order chunks are stored in.
+func (h *Header) LineOrder() int {
+	return h.lineOrder
+}
+
+// SetChromaticities sets the CIE x,y coordinates of the RGB primaries and white point of the image.
+func (h *Header) SetChromaticities(c Chromaticities) {
+	h.chromaticities = &c
+}
+
+// Chromaticities returns the primaries and white point of the image, if the header doesn't have a
+// chromaticities attribute then Rec709Chromaticities are returned.
+func (h *Header) Chromaticities() Chromaticities {
+	if h.chromaticities == nil {
+		return Rec709Chromaticities
+	}
+
+	return *h.chromaticities
+}
+
+// SetPixelAspectRatio sets the width divided by the height of a pixel when the image is displayed.
+func (h *Header) SetPixelAspectRatio(r float32) {
+	h.pixelAspectRatio = r
+}
+
+// PixelAspectRatio returns the width divided by the height of a pixel when the image is displayed.
+func (h *Header) PixelAspectRatio() float32 {
+	return h.pixelAspectRatio
+}
+
+// SetScreenWindow sets the center and width of the screen window, the perspective projection onto the
+// display window.
+func (h *Header) SetScreenWindow(center V2f, width float32) {
+	h.screenWindowCenter = center
+	h.screenWindowWidth = width
+}
+
+// ScreenWindow returns the center and width of the screen window.
+func (h *Header) ScreenWindow() (center V2f, width float32) {
+	return h.screenWindowCenter, h.screenWindowWidth
+}
+
 func (h *Header) SetTileDescription(td TileDescription) {
 	h.tileDescription = td
 	h.tiled = true
 }
 
+// checkSampling checks that the data window is compatible with the sampling rates of every channel.
+// The data window origin and size must be a multiple of each channel's x and y sampling.
+func (h *Header) checkSampling() error {
+	width := int(h.dataWindow[2] - h.dataWindow[0] + 1)
+	height := int(h.dataWindow[3] - h.dataWindow[1] + 1)
+
+	for _, ch := range h.channels {
+		xs, ys := int(ch.XSampling), int(ch.YSampling)
+
+		if xs < 1 || ys < 1 {
+			return fmt.Errorf("invalid sampling (%v, %v) for channel %v", xs, ys, ch.Name)
+		}
+
+		if h.tiled && (xs != 1 || ys != 1) {
+			return fmt.Errorf("channel %v is sub-sampled, tiled images cannot contain sub-sampled channels", ch.Name)
+		}
+
+		if mod(int(h.dataWindow[0]), xs) != 0 || mod(int(h.dataWindow[1]), ys) != 0 {
+			return fmt.Errorf("data window minimum is not a multiple of the sampling (%v, %v) of channel %v", xs, ys, ch.Name)
+		}
+
+		if width%xs != 0 || height%ys != 0 {
+			return fmt.Errorf("data window size is not a multiple of the sampling (%v, %v) of channel %v", xs, ys, ch.Name)
+		}
+	}
+
+	return nil
+}
+
+// chunkSize returns the size in bytes of the uncompressed pixel data for the n scanlines starting at y.
+func (h *Header) chunkSize(y, n int) int {
+	size := 0
+
+	for ; n > 0; n-- {
+		for _, ch := range h.channels {
+			if mod(y, int(ch.YSampling)) == 0 {
+				size += numSamples(int(ch.XSampling), int(h.dataWindow[0]), int(h.dataWindow[2])) * pixelTypeSize(ch.PixelType)
+			}
+		}
+		y++
+	}
+
+	return size
+}
+
+// pixelTypeSize returns the size in bytes of a single sample of the given type.
+func pixelTypeSize(pixelType int32) int {
+	if pixelType == PixelTypeHalf {
+		return 2
+	}
+
+	return 4
+}
+
+// divp returns x/y rounded towards negative infinity.
+func divp(x, y int) int {
+	if x < 0 {
+		return -((y - x - 1) / y)
+	}
+
+	return x / y
+}
+
+// mod returns x modulo y, the result is always positive.
+func mod(x, y int) int {
+	return x - y*divp(x, y)
+}
+
+// numSamples returns the number of samples between a and b (inclusive) for the given sampling rate.
+func numSamples(s, a, b int) int {
+	return divp(b, s) - divp(a-1, s)
+}
+
+// sampling returns the sampling rates of the slice, zero is treated as no sub-sampling.
+func (p *Pixels) sampling() (xs, ys int) {
+	xs, ys = p.XSampling, p.YSampling
+
+	if xs == 0 {
+		xs = 1
+	}
+
+	if ys == 0 {
+		ys = 1
+	}
+
+	return
+}
+
+// offset returns the index in Data of the sample for pixel (x, y).
+func (p *Pixels) offset(x, y int) int {
+	xs, ys := p.sampling()
+	return int(p.Base) + divp(x, xs)*int(p.XStride) + divp(y, ys)*int(p.YStride)
+}
+
+// at returns the sample for pixel (x, y) as a float32.
+func (p *Pixels) at(x, y int) (float32, error) {
+	ofs := p.offset(x, y)
+
+	switch t := p.Data.(type) {
+	case []float32:
+		if ofs >= 0 && ofs < len(t) {
+			return t[ofs], nil
+		}
+	case []Half:
+		if ofs >= 0 && ofs < len(t) {
+			return Float16ToFloat32(Float16(t[ofs])), nil
+		}
+	case []uint32:
+		if ofs >= 0 && ofs < len(t) {
+			return float32(t[ofs]), nil
+		}
+	default:
+		return 0, fmt.Errorf("invalid pixel type (%T)", p.Data)
+	}
+
+	return 0, fmt.Errorf("pixel (%v, %v) is outside framebuffer slice", x, y)
+}
+
+// set stores v as the sample for pixel (x, y).
+func (p *Pixels) set(x, y int, v float32) error {
+	ofs := p.offset(x, y)
+
+	switch t := p.Data.(type) {
+	case []float32:
+		if ofs >= 0 && ofs < len(t) {
+			t[ofs] = v
+			return nil
+		}
+	case []Half:
+		if ofs >= 0 && ofs < len(t) {
+			t[ofs] = Half(Float32ToFloat16(v))
+			return nil
+		}
+	case []uint32:
+		if ofs >= 0 && ofs < len(t) {
+			t[ofs] = floatToUint(v)
+			return nil
+		}
+	default:
+		return fmt.Errorf("invalid pixel type (%T)", p.Data)
+	}
+
+	return fmt.Errorf("pixel (%v, %v) is outside framebuffer slice", x, y)
+}
+
+// checkSampling returns an error if the slice sampling does not match that of the channel.
+func (p *Pixels) checkSampling(ch *Channel) error {
+	xs, ys := p.sampling()
+
+	if xs != int(ch.XSampling) || ys != int(ch.YSampling) {
+		return fmt.Errorf("framebuffer sampling (%v, %v) does not match sampling (%v, %v) of channel %v",
+			xs, ys, ch.XSampling, ch.YSampling, ch.Name)
+	}
+
+	return nil
+}
+
+// encodeLine appends the samples of scanline y between xMin and xMax to buf, converted to pixelType.
+// Only columns that are a multiple of the x sampling have a sample.
+func (p *Pixels) encodeLine(buf []byte, pixelType int32, y, xMin, xMax int) ([]byte, error) {
+	switch t := p.Data.(type) {
+	case []float32:
+		return encodeSamples(buf, t, p, pixelType, y, xMin, xMax)
+	case []Half:
+		return encodeSamples(buf, t, p, pixelType, y, xMin, xMax)
+	case []uint32:
+		return encodeSamples(buf, t, p, pixelType, y, xMin, xMax)
+	}
+
+	return nil, fmt.Errorf("invalid pixel type (%T)", p.Data)
+}
+
+// decodeLine stores the samples of scanline y between xMin and xMax from data, which holds samples
+// of pixelType.  It returns the remaining data.
+func (p *Pixels) decodeLine(data []byte, pixelType int32, y, xMin, xMax int) ([]byte, error) {
+	switch t := p.Data.(type) {
+	case []float32:
+		return decodeSamples(data, t, p, pixelType, y, xMin, xMax)
+	case []Half:
+		return decodeSamples(data, t, p, pixelType, y, xMin, xMax)
+	case []uint32:
+		return decodeSamples(data, t, p, pixelType, y, xMin, xMax)
+	}
+
+	return nil, fmt.Errorf("invalid pixel type (%T)", p.Data)
+}
+
+// fillLine sets the samples of scanline y between xMin and xMax to FillValue.
+func (p *Pixels) fillLine(y, xMin, xMax int) error {
+	switch t := p.Data.(type) {
+	case []float32:
+		return fillSamples(t, p, y, xMin, xMax)
+	case []Half:
+		return fillSamples(t, p, y, xMin, xMax)
+	case []uint32:
+		return fillSamples(t, p, y, xMin, xMax)
+	}
+
+	return fmt.Errorf("invalid pixel type (%T)", p.Data)
+}
+
 type fbChannel struct {
 	name   string
 	pixels Pixels
@@ -97,28 +391,62 @@ func (fb *Framebuffer) Insert(ch string, pixels Pixels) {
 	}{ch, pixels})
 }
 
+// find returns the pixels for the named channel or nil if the framebuffer doesn't contain the channel.
+func (fb *Framebuffer) find(name string) *Pixels {
+	for i := range fb.channels {
+		if fb.channels[i].name == name {
+			return &fb.channels[i].pixels
+		}
+	}
+
+	return nil
+}
+
 type OutputFile struct {
 	header      Header
 	framebuffer Framebuffer
 
 	w io.WriteSeeker
 
-	versionWritten bool
-	headerOfs      int64
 	headerWritten  bool
 	offsetTableOfs int64
 	offsetTable    []uint64
-	chunkOfs       int64
 
 	numChunks int
 
 	currentScanline int
+	chunkBuf        []byte // Uncompressed pixel data of the chunk currently being assembled
+
+	previewOptions *PreviewOptions // Generate the preview from the framebuffer if not nil
+	previewOfs     int64           // File offset of the preview pixel data
+
+	aces bool // Header must be an ACES image container
+
+	workers        int // Goroutines compressing chunks
+	chunksInFlight int // Chunks held in memory while compressing
+
+	mu        sync.Mutex        // Serialises writing to the file and the offset table
+	held      map[int]*chunkJob // Compressed chunks waiting for earlier chunks to be written
+	nextChunk int               // Next chunk to write unless the line order is random
+
+	progress      func(done, total int) // Reports chunks written to the file
+	chunksWritten int
 }
 
 func NewOutputFile(w io.WriteSeeker, h Header) *OutputFile {
+	return NewOutputFileOptions(w, h, nil)
+}
+
+// NewOutputFileOptions returns an OutputFile which compresses chunks as given by opts, nil gives the
+// defaults.
+func NewOutputFileOptions(w io.WriteSeeker, h Header, opts *WriteOptions) *OutputFile {
 	return &OutputFile{
-		header: h,
-		w:      w,
+		header:          h,
+		w:               w,
+		currentScanline: int(h.dataWindow[1]),
+		workers:         opts.workers(),
+		chunksInFlight:  opts.chunksInFlight(),
+		progress:        opts.progressFunc(),
 	}
 }
 
@@ -139,19 +467,43 @@ func (o *OutputFile) stdAttribs() []attrib {
 		o.header.displayWindow[2],
 		o.header.displayWindow[3]}})
 
-	attribs = append(attribs, attrib{"pixelAspectRatio", float32(1)})
+	attribs = append(attribs, attrib{"pixelAspectRatio", o.header.pixelAspectRatio})
 
-	attribs = append(attribs, attrib{"screenWindowWidth", float32(1)})
+	attribs = append(attribs, attrib{"screenWindowWidth", o.header.screenWindowWidth})
 
-	attribs = append(attribs, attrib{"screenWindowCenter", V2f{}})
+	attribs = append(attribs, attrib{"screenWindowCenter", o.header.screenWindowCenter})
 
-	attribs = append(attribs, attrib{"compression", Compression(CompressionTypeNone)})
-	attribs = append(attribs, attrib{"lineOrder", LineOrder(LineOrderIncreasingY)})
+	attribs = append(attribs, attrib{"compression", Compression(o.header.compression)})
+	attribs = append(attribs, attrib{"lineOrder", LineOrder(o.header.lineOrder)})
 
 	attribs = append(attribs, attrib{"chunkCount", int32(o.numChunks)})
 
+	if o.header.chromaticities != nil {
+		attribs = append(attribs, attrib{"chromaticities", *o.header.chromaticities})
+	}
+
+	if o.header.adoptedNeutral != nil {
+		attribs = append(attribs, attrib{"adoptedNeutral", *o.header.adoptedNeutral})
+	}
+
+	if o.header.acesContainer {
+		attribs = append(attribs, attrib{"acesImageContainerFlag", int32(1)})
+	}
+
+	if o.header.preview != nil {
+		attribs = append(attribs, attrib{"preview", *o.header.preview})
+	}
+
+	if len(o.header.multiView) > 0 {
+		attribs = append(attribs, attrib{"multiView", Stringvector(o.header.multiView)})
+	}
+
+	if o.header.view != "" {
+		attribs = append(attribs, attrib{"view", o.header.view})
+	}
+
 	attribs = append(attribs, attrib{"channels", Chlist(o.header.channels)})
-	fmt.Printf("chlist:%v\n", Chlist(o.header.channels))
+
 	return attribs
 }
 
@@ -166,66 +518,172 @@ func writeAttrib(w io.Writer, v interface{}) error {
 
 		w.Write(b)
 
+	case string:
+		// The attribute size gives the length of a string attribute
+		io.WriteString(w, t)
+
 	default:
 		binary.Write(w, binary.LittleEndian, v)
 	}
 	return nil
 }
 
-func writeFloat32AsHalf(buf *bytes.Buffer, v float32) error {
-	return binary.Write(buf, binary.LittleEndian, Half(Float32ToFloat16(v)))
+// appendFloat32 appends v to buf converted to pixelType.
+func appendFloat32(buf []byte, pixelType int32, v float32) []byte {
+	switch pixelType {
+	case PixelTypeUInt:
+		return binary.LittleEndian.AppendUint32(buf, floatToUint(v))
+	case PixelTypeHalf:
+		return binary.LittleEndian.AppendUint16(buf, uint16(Float32ToFloat16(v)))
+	}
+
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
 }
 
-// WritePixels writes scanlines between y=start and y=end
-func (o *OutputFile) WritePixels(count int) error {
+// appendHalf appends v to buf converted to pixelType.
+func appendHalf(buf []byte, pixelType int32, v Half) []byte {
+	if pixelType == PixelTypeHalf {
+		return binary.LittleEndian.AppendUint16(buf, uint16(v))
+	}
 
-	if o.header.tiled {
-		return fmt.Errorf("attempting to write scanlines to a tiled image")
+	return appendFloat32(buf, pixelType, Float16ToFloat32(Float16(v)))
+}
+
+// appendUint appends v to buf converted to pixelType.
+func appendUint(buf []byte, pixelType int32, v uint32) []byte {
+	if pixelType == PixelTypeUInt {
+		return binary.LittleEndian.AppendUint32(buf, v)
+	}
+
+	return appendFloat32(buf, pixelType, float32(v))
+}
+
+// sampleFloat32 returns the first sample in data, which is of pixelType, as a float32.
+func sampleFloat32(data []byte, pixelType int32) float32 {
+	switch pixelType {
+	case PixelTypeUInt:
+		return float32(binary.LittleEndian.Uint32(data))
+	case PixelTypeHalf:
+		return Float16ToFloat32(Float16(binary.LittleEndian.Uint16(data)))
+	}
+
+	return math.Float32frombits(binary.LittleEndian.Uint32(data))
+}
+
+// sampleHalf returns the first sample in data, which is of pixelType, as a Half.
+func sampleHalf(data []byte, pixelType int32) Half {
+	if pixelType == PixelTypeHalf {
+		return Half(binary.LittleEndian.Uint16(data))
+	}
+
+	return Half(Float32ToFloat16(sampleFloat32(data, pixelType)))
+}
+
+// sampleUint returns the first sample in data, which is of pixelType, as a uint32.
+func sampleUint(data []byte, pixelType int32) uint32 {
+	if pixelType == PixelTypeUInt {
+		return binary.LittleEndian.Uint32(data)
+	}
+
+	return floatToUint(sampleFloat32(data, pixelType))
+}
+
+// floatToUint converts to an unsigned int, negative values and NaN become 0 and large values are clamped.
+func floatToUint(v float32) uint32 {
+	if v != v || v < 0 {
+		return 0
+	}
+
+	if v >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(v)
+}
+
+func (o *OutputFile) writeHeader() error {
+	if o.aces {
+		if err := o.header.ValidateACES(); err != nil {
+			return err
+		}
 	}
 
 	bufW := bufio.NewWriter(o.w)
 
-	if !o.versionWritten {
-		version := EXRVersion{}
-		// fill version details.
-		WriteVersion(&version, bufW)
-		bufW.Flush()
+	height := int(o.header.dataWindow[3] - o.header.dataWindow[1] + 1)
+	linesPerChunk := linesPerChunk(o.header.compression)
+
+	o.numChunks = (height + linesPerChunk - 1) / linesPerChunk
+
+	if o.previewOptions != nil {
+		o.header.preview = o.newPreview()
 	}
 
-	if !o.headerWritten {
-		linesPerChunk := 1
-		numChunks := int(((o.header.dataWindow[3] - o.header.dataWindow[1] + 1) / int32(linesPerChunk))) // yMax-yMin
+	attribs := o.stdAttribs()
 
-		o.numChunks = numChunks
+	version := EXRVersion{}
 
-		// Write header
-		ofs, err := o.w.Seek(0, io.SeekCurrent)
+	// Names longer than 31 bytes need the long name flag set.
+	var names []string
 
-		if err != nil {
-			return fmt.Errorf("finding current file position: %v", err)
+	for _, attrib := range attribs {
+		names = append(names, attrib.name, attribType(attrib.val))
+	}
+
+	for _, ch := range o.header.channels {
+		names = append(names, ch.Name)
+	}
+
+	for _, name := range names {
+		if len(name) > maxLongNameLength {
+			return fmt.Errorf("name %q is longer than %v bytes", name, maxLongNameLength)
 		}
 
-		o.headerOfs = ofs
+		if len(name) > maxShortNameLength {
+			version.longName = true
+		}
+	}
 
-		attribs := o.stdAttribs()
+	if err := WriteVersion(&version, bufW); err != nil {
+		return err
+	}
 
-		for _, attrib := range attribs {
-			buf := bytes.Buffer{}
-			writeAttrib(&buf, attrib.val)
-			//binary.Write(&buf, binary.LittleEndian, attrib.val)
+	for _, attrib := range attribs {
+		if attrib.name == "preview" && o.previewOptions != nil {
+			// Remember where the preview pixels are so they can be filled in as scanlines are written
+			if err := bufW.Flush(); err != nil {
+				return fmt.Errorf("writing header: %v", err)
+			}
+
+			ofs, err := o.w.Seek(0, io.SeekCurrent)
 
-			a := &EXRAttribute{name: attrib.name,
-				attribType: attribType(attrib.val),
-				value:      buf.Bytes(),
+			if err != nil {
+				return fmt.Errorf("finding current file position: %v", err)
 			}
 
-			fmt.Printf("Write %v: %v: %v\n", a.name, attrib.val, a.value)
-			WriteAttrib(a, bufW)
+			o.previewOfs = ofs + int64(len(attrib.name)+len("preview")+2+4+8)
+		}
+
+		buf := bytes.Buffer{}
+
+		if err := writeAttrib(&buf, attrib.val); err != nil {
+			return err
 		}
 
-		WriteAttrib(nil, bufW)
-		bufW.Flush()
+		a := &EXRAttribute{name: attrib.name,
+			attribType: attribType(attrib.val),
+			value:      buf.Bytes(),
+		}
+
+		if err := WriteAttrib(a, bufW); err != nil {
+			return err
+		}
+	}
+
+	WriteAttrib(nil, bufW)
 
+	if err := bufW.Flush(); err != nil {
+		return fmt.Errorf("writing header: %v", err)
 	}
 
 	ofs, err := o.w.Seek(0, io.SeekCurrent)
@@ -236,144 +694,386 @@ func (o *OutputFile) WritePixels(count int) error {
 
 	o.offsetTableOfs = ofs
 
-	// The number of scan lines in a block depends on the compression.
-	// NO_COMPRESSION  1
-	// RLE_COMPRESSION 1
-	// ZIPS_COMPRESSION 1
-	// ZIP_COMPRESSION 16
-	// PIZ_COMPRESSION 32
-	// PXR24_COMPRESSION 16
-	// B44_COMPRESSION 32
-	// B44A_COMPRESSION 32
+	// For scan line blocks the line offset table is a sequence of scan line offsets with
+	// one offset per scan line block.
+	o.offsetTable = make([]uint64, o.numChunks)
+
+	// Initially write the chunk slice to reserve space even though we don't know the offsets
+	if err := binary.Write(o.w, binary.LittleEndian, o.offsetTable); err != nil {
+		return fmt.Errorf("writing offset table: %v", err)
+	}
+
+	o.headerWritten = true
+
+	return nil
+}
+
+// writeChunk compresses and writes the pixel data for the given scanline block, y is the first line in the block.
+func (o *OutputFile) writeChunk(chunk, y int, raw []byte) error {
+	data, err := compressChunk(o.header.compression, raw)
+
+	if err != nil {
+		return err
+	}
+
+	return o.writeChunkData(chunk, y, data)
+}
+
+// writeChunkData writes the compressed pixel data of a scanline block, o.mu must be held.  Unless the line
+// order is random a chunk is kept in memory until the chunks before it have been written, so that the
+// chunks are stored in order of increasing y.
+func (o *OutputFile) writeChunkData(chunk, y int, data []byte) error {
+	if o.offsetTable[chunk] != 0 || o.held[chunk] != nil {
+		return fmt.Errorf("chunk %v has already been written", chunk)
+	}
+
+	if o.header.lineOrder == LineOrderRandomY {
+		return o.appendChunk(chunk, y, data)
+	}
+
+	if chunk != o.nextChunk {
+		if o.held == nil {
+			o.held = make(map[int]*chunkJob)
+		}
+
+		// The data may share the caller's buffer
+		o.held[chunk] = &chunkJob{chunk: chunk, y: y, data: append([]byte(nil), data...)}
+
+		return nil
+	}
+
+	if err := o.appendChunk(chunk, y, data); err != nil {
+		return err
+	}
+
+	for o.nextChunk++; o.held[o.nextChunk] != nil; o.nextChunk++ {
+		job := o.held[o.nextChunk]
+		delete(o.held, o.nextChunk)
+
+		if err := o.appendChunk(job.chunk, job.y, job.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendChunk writes the compressed pixel data of a scanline block at the end of the file.
+func (o *OutputFile) appendChunk(chunk, y int, data []byte) error {
+	ofs, err := o.w.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return fmt.Errorf("finding current file position: %v", err)
+	}
+
+	o.offsetTable[chunk] = uint64(ofs)
 
 	// Then chunk layout is
 	// [part number]  (if multipart file)
 	// y coordinate
 	// pixel data size  (int, in bytes)
 	// pixel data
-	linesPerChunk := 1                                                                          // Assume NO_COMPRESSION to start
-	numChunks := ((o.header.dataWindow[3] - o.header.dataWindow[1] + 1) / int32(linesPerChunk)) // yMax-yMin
+	var chunkHeader [8]byte
 
-	// Write offset table
+	binary.LittleEndian.PutUint32(chunkHeader[0:], uint32(int32(y)))
+	binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(len(data)))
 
-	// For scan line blocks the line offset table is a sequence of scan line offsets with
-	// one offset per scan line block.
+	if _, err := o.w.Write(chunkHeader[:]); err != nil {
+		return fmt.Errorf("writing chunk %v: %v", chunk, err)
+	}
 
-	o.offsetTable = make([]uint64, numChunks)
+	if _, err := o.w.Write(data); err != nil {
+		return fmt.Errorf("writing chunk %v: %v", chunk, err)
+	}
 
-	fmt.Printf("%v chunks\n", numChunks)
+	o.chunksWritten++
 
-	// Initially write the chunk slice to reserve space even though we don't know the offsets
-	binary.Write(o.w, binary.LittleEndian, o.offsetTable)
+	if o.progress != nil {
+		o.progress(o.chunksWritten, o.numChunks)
+	}
+
+	return nil
+}
+
+// WritePixels writes the next count scanlines from the framebuffer.  Chunks are compressed in parallel,
+// they're written in order of increasing y unless the line order is LineOrderRandomY.
+func (o *OutputFile) WritePixels(count int) error {
+	return o.WritePixelsContext(context.Background(), count)
+}
+
+// WritePixelsContext is like WritePixels but stops between chunks once ctx is cancelled, returning
+// ctx.Err().  The file is then incomplete.
+func (o *OutputFile) WritePixelsContext(ctx context.Context, count int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 
-	ofs, err = o.w.Seek(0, io.SeekCurrent)
+	if err := o.startWriting(); err != nil {
+		return err
+	}
+
+	yMax := int(o.header.dataWindow[3])
+
+	if o.currentScanline+count-1 > yMax {
+		return fmt.Errorf("attempting to write %v scanlines from %v, beyond data window", count, o.currentScanline)
+	}
+
+	var cw *chunkWriter
+
+	if o.workers > 1 && o.header.compression != CompressionTypeNone {
+		cw = newChunkWriter(o, o.workers, o.chunksInFlight)
+	}
+
+	err := o.writeScanlines(ctx, count, cw)
+
+	if cw != nil {
+		err = cw.close(err)
+	}
 
 	if err != nil {
-		return fmt.Errorf("finding current file position: %v", err)
+		return err
 	}
 
-	o.chunkOfs = ofs
+	return o.writeOffsetTable()
+}
 
-	y := int32(o.currentScanline) // o.header.dataWindow[1]
+// startWriting checks the header and writes it if this is the first call to write pixels, o.mu must be
+// held.
+func (o *OutputFile) startWriting() error {
+	if o.header.tiled {
+		return fmt.Errorf("attempting to write scanlines to a tiled image")
+	}
 
-	// Need to make sure the framebuffer channels are sorted.
-	sort.Sort(o.framebuffer.channels)
+	if err := o.header.Validate(); err != nil {
+		return err
+	}
 
-	for chunk := int32(0); chunk < numChunks; chunk++ {
+	// Chunks are written as the scanlines arrive, in order of increasing y
+	if o.header.lineOrder == LineOrderDecreasingY {
+		return fmt.Errorf("writing images with decreasing y line order is not supported")
+	}
 
-		buf := &bytes.Buffer{}
+	if !o.headerWritten {
+		if err := o.writeHeader(); err != nil {
+			return err
+		}
+	}
 
-		ofs, err = o.w.Seek(0, io.SeekCurrent)
+	return nil
+}
 
-		if err != nil {
-			return fmt.Errorf("finding current file position: %v", err)
+// writeOffsetTable writes the offset table and generated preview, which have been updated by writing
+// chunks, o.mu must be held.
+func (o *OutputFile) writeOffsetTable() error {
+	ofs, err := o.w.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return fmt.Errorf("finding current file position: %v", err)
+	}
+
+	if _, err := o.w.Seek(o.offsetTableOfs, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to offset table: %v", err)
+	}
+
+	if err := binary.Write(o.w, binary.LittleEndian, o.offsetTable); err != nil {
+		return fmt.Errorf("writing offset table: %v", err)
+	}
+
+	if o.previewOptions != nil {
+		if err := o.writePreview(); err != nil {
+			return err
 		}
+	}
+
+	if _, err := o.w.Seek(ofs, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to end of file: %v", err)
+	}
+
+	return nil
+}
 
-		o.offsetTable[chunk] = uint64(ofs)
+// writeScanlines encodes the next count scanlines from the framebuffer and writes each chunk as it's
+// completed, compressing on cw if it isn't nil.  Before each chunk is started ctx is checked.
+func (o *OutputFile) writeScanlines(ctx context.Context, count int, cw *chunkWriter) error {
+	yMin, yMax := int(o.header.dataWindow[1]), int(o.header.dataWindow[3])
 
-		fmt.Printf("Writing chunk %v (ofs %v)\n", chunk, ofs)
+	linesPerChunk := linesPerChunk(o.header.compression)
 
-		// If framebuffer doesn't contain Pixels for a given Channel then the channel is filled with default value in file.
-		// If framebuffer has Pixels for non-existent Channel then the pixels are skipped.
-		// Unlike Ilm library there will be a seperate base value from the slice.
+	for i := 0; i < count; i++ {
+		y := o.currentScanline
 
-		// Pixel data is channels in alphabetical order of either byte, half or float
-		for _, ch := range o.framebuffer.channels {
+		if (y-yMin)%linesPerChunk == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
 
-			// 1) Check channel exists in file header.
-			headerChan := o.header.FindChannel(ch.name)
+		buf, err := o.encodeScanline(o.chunkBuf, y)
 
-			if headerChan != nil {
+		if err != nil {
+			return err
+		}
 
+		o.chunkBuf = buf
+
+		if o.previewOptions != nil {
+			if err := o.updatePreview(y); err != nil {
+				return err
 			}
+		}
 
-			//pixelOfs := ch.pixels.Base + 0*ch.pixels.XStride + y*ch.pixels.YStride
-			pixelBaseOfs := ch.pixels.Base + y*ch.pixels.YStride
-
-			// This isn't right, strides need to be taken into account for each pixel (rather than just writing whole lot)
-			// OK, done that but should be converting & writing the given data into the type specified in HEADER, not that
-			// of the passed in buffer.
-			switch t := ch.pixels.Data.(type) {
-			case []float32:
-				for x := o.header.dataWindow[0]; x <= o.header.dataWindow[2]; x++ {
-					pixelOfs := pixelBaseOfs + x*ch.pixels.XStride
-
-					switch headerChan.PixelType {
-					case PixelTypeUInt:
-						//			writeFloat32AsUInt(buf, t[pixelOfs])
-					case PixelTypeHalf:
-						writeFloat32AsHalf(buf, t[pixelOfs])
-					default:
-						binary.Write(buf, binary.LittleEndian, t[pixelOfs])
-					}
-					//fmt.Printf("Pixel %v %v %v\n", ch.name, x, t[pixelOfs])
-				}
-				//binary.Write(o.w, binary.LittleEndian, t[pixelOfs:pixelOfs+(o.header.dataWindow[2]-o.header.dataWindow[0])])
-			case []uint8:
-				for x := o.header.dataWindow[0]; x <= o.header.dataWindow[2]; x++ {
-					pixelOfs := pixelBaseOfs + x*ch.pixels.XStride
-					binary.Write(buf, binary.LittleEndian, t[pixelOfs])
-				}
-				///binary.Write(o.w, binary.LittleEndian, t[pixelOfs:pixelOfs+(o.header.dataWindow[2]-o.header.dataWindow[0])])
-			case []Half:
-				for x := o.header.dataWindow[0]; x <= o.header.dataWindow[2]; x++ {
-					pixelOfs := pixelBaseOfs + x*ch.pixels.XStride
-					binary.Write(buf, binary.LittleEndian, t[pixelOfs])
+		o.currentScanline++
+
+		if (y-yMin+1)%linesPerChunk == 0 || y == yMax {
+			chunk := (y - yMin) / linesPerChunk
+
+			if cw == nil {
+				if err := o.writeChunk(chunk, yMin+chunk*linesPerChunk, o.chunkBuf); err != nil {
+					return err
 				}
-				//binary.Write(o.w, binary.LittleEndian, t[pixelOfs:pixelOfs+(o.header.dataWindow[2]-o.header.dataWindow[0])])
-			default:
-				return fmt.Errorf("invalid pixel type (%T) for channel %v", t, ch.name)
+
+				o.chunkBuf = o.chunkBuf[:0]
+				continue
 			}
+
+			if err := cw.add(chunk, yMin+chunk*linesPerChunk, o.chunkBuf); err != nil {
+				return err
+			}
+
+			o.chunkBuf = cw.buffer()
+		}
+	}
+
+	return nil
+}
+
+// encodeScanline appends the pixel data of scanline y from the framebuffer to buf.
+func (o *OutputFile) encodeScanline(buf []byte, y int) ([]byte, error) {
+	xMin, xMax := int(o.header.dataWindow[0]), int(o.header.dataWindow[2])
+
+	// If framebuffer doesn't contain Pixels for a given Channel then the channel is filled with zero in file.
+	// If framebuffer has Pixels for non-existent Channel then the pixels are skipped.
+	// Unlike Ilm library there will be a seperate base value from the slice.
+
+	// Pixel data is channels in alphabetical order of either uint, half or float, sub-sampled channels
+	// only have data on lines and columns which are a multiple of the sampling rate.
+	for k := range o.header.channels {
+		ch := &o.header.channels[k]
+
+		if mod(y, int(ch.YSampling)) != 0 {
+			continue
 		}
 
-		if false { // multipart
-			binary.Write(o.w, binary.LittleEndian, int32(0))
+		pixels := o.framebuffer.find(ch.Name)
+
+		if pixels == nil {
+			n := numSamples(int(ch.XSampling), xMin, xMax) * pixelTypeSize(ch.PixelType)
+			buf = append(buf, make([]byte, n)...)
+			continue
 		}
 
-		binary.Write(o.w, binary.LittleEndian, int32(y))
-		binary.Write(o.w, binary.LittleEndian, int32(buf.Len())) // data size
-		o.w.Write(buf.Bytes())
+		if err := pixels.checkSampling(ch); err != nil {
+			return nil, err
+		}
 
-		y += int32(linesPerChunk)
+		var err error
+
+		buf, err = pixels.encodeLine(buf, ch.PixelType, y, xMin, xMax)
+
+		if err != nil {
+			return nil, fmt.Errorf("channel %v: %v", ch.Name, err)
+		}
 	}
 
-	o.currentScanline = int(y)
+	return buf, nil
+}
 
-	// Offset table has been updated, seek to the start and write it out.
-	_, err = o.w.Seek(o.offsetTableOfs, io.SeekStart)
+// WriteScanlines writes the scanlines between y1 and y2 (inclusive) from the framebuffer.  It may be called
+// from several goroutines at once for disjoint blocks of scanlines, each block must start at the first
+// line of a chunk and end at the last line of a chunk or of the data window.  The pixels are encoded and
+// compressed on the calling goroutine, only writing to the file is serialised.  Unless the line order is
+// LineOrderRandomY chunks written ahead of earlier chunks are kept in memory until those are written.
+// WritePixels and WriteScanlines shouldn't be used on the same file.
+func (o *OutputFile) WriteScanlines(y1, y2 int) error {
+	return o.WriteScanlinesContext(context.Background(), y1, y2)
+}
+
+// WriteScanlinesContext is like WriteScanlines but stops between chunks once ctx is cancelled, returning
+// ctx.Err().  The file is then incomplete.
+func (o *OutputFile) WriteScanlinesContext(ctx context.Context, y1, y2 int) error {
+	o.mu.Lock()
+	err := o.startWriting()
+	o.mu.Unlock()
 
 	if err != nil {
-		return fmt.Errorf("finding current file position: %v", err)
+		return err
 	}
 
-	binary.Write(o.w, binary.LittleEndian, o.offsetTable)
+	yMin, yMax := int(o.header.dataWindow[1]), int(o.header.dataWindow[3])
 
-	// At this point we should have a complete file
+	if y1 > y2 || y1 < yMin || y2 > yMax {
+		return fmt.Errorf("scanlines %v to %v are outside data window", y1, y2)
+	}
 
-	return nil
+	linesPerChunk := linesPerChunk(o.header.compression)
+
+	if (y1-yMin)%linesPerChunk != 0 || ((y2-yMin+1)%linesPerChunk != 0 && y2 != yMax) {
+		return fmt.Errorf("scanlines %v to %v aren't whole chunks of %v scanlines", y1, y2, linesPerChunk)
+	}
+
+	var raw []byte
+
+	for y := y1; y <= y2; y += linesPerChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		last := min(y+linesPerChunk-1, yMax)
+
+		raw = raw[:0]
+
+		for line := y; line <= last; line++ {
+			if raw, err = o.encodeScanline(raw, line); err != nil {
+				return err
+			}
+		}
+
+		data, err := compressChunk(o.header.compression, raw)
+
+		if err != nil {
+			return err
+		}
+
+		if err := o.writeChunkLines(y, last, data); err != nil {
+			return err
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.writeOffsetTable()
+}
+
+// writeChunkLines writes the compressed chunk holding scanlines y1 to y2 and updates the preview from them.
+func (o *OutputFile) writeChunkLines(y1, y2 int, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.previewOptions != nil {
+		for y := y1; y <= y2; y++ {
+			if err := o.updatePreview(y); err != nil {
+				return err
+			}
+		}
+	}
+
+	yMin := int(o.header.dataWindow[1])
 
+	return o.writeChunkData((y1-yMin)/linesPerChunk(o.header.compression), y1, data)
 }
 
+// WriteTiles is a placeholder until tiled images can be written, like WriteScanlines it will be safe to
+// call from several goroutines for disjoint tiles.
 func (o *OutputFile) WriteTiles(start, end int) error {
 	return nil
 }
@@ -0,0 +1,521 @@
+package exr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// InputFile reads the pixels of a scanline EXR image into a Framebuffer.  Chunks are read by offset and
+// decoded in parallel, ReadPixels may also be called from several goroutines at once for different
+// scanlines.
+type InputFile struct {
+	header      Header
+	framebuffer Framebuffer
+
+	r    io.ReaderAt
+	size int64
+
+	version     *EXRVersion
+	offsetTable []uint64
+
+	limits       Limits
+	decompressed int64   // Total size of the pixel data decompressed so far
+	counted      []int32 // Set once a chunk's size has been added to decompressed
+	workers      int     // Goroutines decoding chunks
+
+	progress func(done, total int) // Reports chunks decoded by ReadPixels
+}
+
+// NewInputFile reads the version, header and offset table from r, DefaultLimits apply.
+func NewInputFile(r io.ReadSeeker) (*InputFile, error) {
+	return NewInputFileOptions(r, nil)
+}
+
+// NewInputFileOptions reads the version, header and offset table from r with the given options, if opts
+// is nil then DefaultLimits apply.  If r is also an io.ReaderAt, as *os.File and *bytes.Reader are, then
+// chunks are read with ReadAt, otherwise reads are serialised.
+func NewInputFileOptions(r io.ReadSeeker, opts *ReadOptions) (*InputFile, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return nil, fmt.Errorf("finding file size: %v", err)
+	}
+
+	if ra, ok := r.(io.ReaderAt); ok {
+		return NewInputFileReaderAt(ra, size, opts)
+	}
+
+	return NewInputFileReaderAt(&seekReaderAt{r: r}, size, opts)
+}
+
+// NewInputFileReaderAt reads the version, header and offset table from the first size bytes of r with
+// the given options, if opts is nil then DefaultLimits apply.
+func NewInputFileReaderAt(r io.ReaderAt, size int64, opts *ReadOptions) (*InputFile, error) {
+	limits := opts.limits()
+
+	sr := io.NewSectionReader(r, 0, size)
+	br := bufio.NewReader(sr)
+
+	version, header, err := readFileHeader(br, limits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &InputFile{
+		header:   header,
+		r:        r,
+		size:     size,
+		version:  version,
+		limits:   limits,
+		workers:  opts.workers(),
+		progress: opts.progressFunc(),
+	}
+
+	if header.tiled {
+		return f, nil
+	}
+
+	if f.offsetTable, err = readOffsetTable(br, &header); err != nil {
+		return nil, err
+	}
+
+	f.counted = make([]int32, len(f.offsetTable))
+
+	pos, err := sr.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return nil, fmt.Errorf("finding current file position: %v", err)
+	}
+
+	f.checkOffsetTable(pos - int64(br.Buffered()))
+
+	return f, nil
+}
+
+// readFileHeader reads the version and header of a file and checks the file can be read.
+func readFileHeader(br *bufio.Reader, limits Limits) (*EXRVersion, Header, error) {
+	version, err := ReadVersion(br)
+
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	if version.multipart || version.nonImage {
+		return nil, Header{}, fmt.Errorf("%w: multi-part and deep images are not supported", ErrUnsupportedVersion)
+	}
+
+	header, err := readHeader(br, version, limits)
+
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	if err := header.Validate(); err != nil {
+		return nil, Header{}, err
+	}
+
+	if !supportedCompression(header.compression) {
+		return nil, Header{}, fmt.Errorf("%w (%v)", ErrUnsupportedCompression, header.compression)
+	}
+
+	return version, header, nil
+}
+
+// readOffsetTable reads the offset table of a scanline image which follows the header.
+func readOffsetTable(br *bufio.Reader, h *Header) ([]uint64, error) {
+	height := int(h.dataWindow[3] - h.dataWindow[1] + 1)
+	linesPerChunk := linesPerChunk(h.compression)
+
+	numChunks := (height + linesPerChunk - 1) / linesPerChunk
+
+	buf, err := readFull(br, numChunks*8)
+
+	if err != nil {
+		return nil, fmt.Errorf("reading offset table: %w", truncated(err))
+	}
+
+	offsetTable := make([]uint64, numChunks)
+
+	for i := range offsetTable {
+		offsetTable[i] = binary.LittleEndian.Uint64(buf[i*8:])
+	}
+
+	return offsetTable, nil
+}
+
+// Header returns the header of the file.
+func (f *InputFile) Header() Header {
+	return f.header
+}
+
+func (f *InputFile) SetFramebuffer(fb Framebuffer) {
+	f.framebuffer = fb
+}
+
+// SetWorkers sets the number of goroutines ReadPixels decodes chunks with, if n is zero then
+// runtime.GOMAXPROCS(0).  With 1 the chunks are decoded in order on the calling goroutine.
+func (f *InputFile) SetWorkers(n int) {
+	f.workers = (&ReadOptions{Workers: n}).workers()
+}
+
+// readChunk reads and decompresses the given scanline block, returning the first line in the block and the
+// number of lines.
+func (f *InputFile) readChunk(chunk int) (data []byte, y, n int, err error) {
+	yMin, yMax := int(f.header.dataWindow[1]), int(f.header.dataWindow[3])
+	linesPerChunk := linesPerChunk(f.header.compression)
+
+	y = yMin + chunk*linesPerChunk
+	n = linesPerChunk
+
+	if y+n-1 > yMax {
+		n = yMax - y + 1
+	}
+
+	if f.offsetTable[chunk] == 0 {
+		return nil, 0, 0, &ChunkError{chunk, fmt.Errorf("%w: chunk is missing from file", ErrTruncated)}
+	}
+
+	ofs := f.offsetTable[chunk]
+
+	if ofs > math.MaxInt64-8 {
+		return nil, 0, 0, &ChunkError{chunk, fmt.Errorf("%w: invalid offset %v", ErrCorruptChunk, ofs)}
+	}
+
+	chunkHeader, err := readAt(f.r, int64(ofs), 8)
+
+	if err != nil {
+		return nil, 0, 0, &ChunkError{chunk, fmt.Errorf("reading chunk header: %w", truncated(err))}
+	}
+
+	dataSize, size, err := f.checkChunkHeader(chunk, y, n, chunkHeader)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	buf, err := readAt(f.r, int64(ofs)+8, dataSize)
+
+	if err != nil {
+		return nil, 0, 0, &ChunkError{chunk, fmt.Errorf("reading pixel data: %w", truncated(err))}
+	}
+
+	data, err = decompressChunk(f.header.compression, buf, size)
+
+	if err != nil {
+		return nil, 0, 0, &ChunkError{chunk, err}
+	}
+
+	return data, y, n, nil
+}
+
+// checkChunkHeader checks the y coordinate and data size in the header of the chunk holding scanlines y to
+// y+n-1, and that decompressing it keeps within the limits.  The size of the data and of the decompressed
+// data are returned.
+func (f *InputFile) checkChunkHeader(chunk, y, n int, chunkHeader []byte) (dataSize, size int, err error) {
+	chunkY := int(int32(binary.LittleEndian.Uint32(chunkHeader[0:])))
+	dataSize = int(int32(binary.LittleEndian.Uint32(chunkHeader[4:])))
+
+	if chunkY != y {
+		return 0, 0, &ChunkError{chunk, fmt.Errorf("%w: y coordinate is %v, expected %v", ErrCorruptChunk, chunkY, y)}
+	}
+
+	size = f.header.chunkSize(y, n)
+
+	if dataSize < 0 || dataSize > size {
+		return 0, 0, &ChunkError{chunk, fmt.Errorf("%w: invalid data size %v", ErrCorruptChunk, dataSize)}
+	}
+
+	// Chunks read again, by later calls to ReadPixels, only count once
+	if !atomic.CompareAndSwapInt32(&f.counted[chunk], 0, 1) {
+		return dataSize, size, nil
+	}
+
+	decompressed := atomic.AddInt64(&f.decompressed, int64(size))
+
+	if limit := f.limits.MaxDecompressedBytes; limit > 0 && decompressed > limit {
+		atomic.AddInt64(&f.decompressed, -int64(size))
+		atomic.StoreInt32(&f.counted[chunk], 0)
+
+		return 0, 0, fmt.Errorf("chunk %v: decompressed pixel data is larger than the limit of %v bytes", chunk, limit)
+	}
+
+	return dataSize, size, nil
+}
+
+// ReadPixels reads the scanlines between y1 and y2 (inclusive) into the framebuffer.  Framebuffer
+// channels which are not in the file are filled with their FillValue.  If scanlines are missing from the
+// file or corrupt the others are still read and an *IncompleteError is returned.  The chunks are decoded
+// in parallel straight into the framebuffer, the result doesn't depend on the number of workers.
+func (f *InputFile) ReadPixels(y1, y2 int) error {
+	return f.ReadPixelsContext(context.Background(), y1, y2)
+}
+
+// ReadPixelsContext is like ReadPixels but stops between chunks once ctx is cancelled, returning ctx.Err().
+// The framebuffer is then only partly filled.
+func (f *InputFile) ReadPixelsContext(ctx context.Context, y1, y2 int) error {
+	if f.header.tiled {
+		return fmt.Errorf("attempting to read scanlines from a tiled image")
+	}
+
+	yMin, yMax := int(f.header.dataWindow[1]), int(f.header.dataWindow[3])
+
+	if y1 > y2 || y1 < yMin || y2 > yMax {
+		return fmt.Errorf("scanlines %v to %v are outside data window", y1, y2)
+	}
+
+	for k := range f.header.channels {
+		ch := &f.header.channels[k]
+
+		if pixels := f.framebuffer.find(ch.Name); pixels != nil {
+			if err := pixels.checkSampling(ch); err != nil {
+				return err
+			}
+		}
+	}
+
+	linesPerChunk := linesPerChunk(f.header.compression)
+	first, last := (y1-yMin)/linesPerChunk, (y2-yMin)/linesPerChunk
+
+	errs := f.decodeChunks(ctx, first, last, y1, y2)
+
+	var incomplete *IncompleteError
+
+	for i, err := range errs {
+		if chunkErr, ok := err.(*ChunkError); ok {
+			if incomplete == nil {
+				incomplete = &IncompleteError{}
+			}
+
+			incomplete.Errs = append(incomplete.Errs, chunkErr)
+
+			if err := f.fillChunk(first+i, y1, y2, &incomplete.Missing); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := f.fillMissingChannels(y1, y2); err != nil {
+		return err
+	}
+
+	if incomplete != nil {
+		return incomplete
+	}
+
+	return nil
+}
+
+// fillMissingChannels fills the scanlines between y1 and y2 of the framebuffer channels which are not in
+// the file with their FillValue.
+func (f *InputFile) fillMissingChannels(y1, y2 int) error {
+	xMin, xMax := int(f.header.dataWindow[0]), int(f.header.dataWindow[2])
+
+	for _, ch := range f.framebuffer.channels {
+		if f.header.FindChannel(ch.name) != nil {
+			continue
+		}
+
+		for y := y1; y <= y2; y++ {
+			if err := ch.pixels.fillLine(y, xMin, xMax); err != nil {
+				return fmt.Errorf("channel %v: %v", ch.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeChunks decodes the chunks from first to last into the framebuffer using up to f.workers
+// goroutines, only the scanlines between y1 and y2 are stored.  The error for each chunk is returned,
+// once a chunk fails with an error other than a ChunkError or ctx is cancelled no more chunks are started.
+func (f *InputFile) decodeChunks(ctx context.Context, first, last, y1, y2 int) []error {
+	errs := make([]error, last-first+1)
+	p := &progress{fn: f.progress, total: len(errs)}
+
+	decode := func(chunk int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := f.decodeChunk(chunk, y1, y2)
+		p.add()
+
+		return err
+	}
+
+	workers := f.workers
+
+	if workers > len(errs) {
+		workers = len(errs)
+	}
+
+	if workers <= 1 {
+		for chunk := first; chunk <= last; chunk++ {
+			err := decode(chunk)
+			errs[chunk-first] = err
+
+			if _, ok := err.(*ChunkError); err != nil && !ok {
+				break
+			}
+		}
+
+		return errs
+	}
+
+	var next int64 = int64(first)
+	var failed int32
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for atomic.LoadInt32(&failed) == 0 {
+				chunk := int(atomic.AddInt64(&next, 1) - 1)
+
+				if chunk > last {
+					return
+				}
+
+				err := decode(chunk)
+				errs[chunk-first] = err
+
+				if _, ok := err.(*ChunkError); err != nil && !ok {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// decodeChunk reads a chunk and stores the scanlines between y1 and y2 in the framebuffer.
+func (f *InputFile) decodeChunk(chunk, y1, y2 int) error {
+	data, y, n, err := f.readChunk(chunk)
+
+	if err != nil {
+		return err
+	}
+
+	return f.decodeLines(data, y, n, y1, y2)
+}
+
+// decodeLines stores the n scanlines from y in the decompressed pixel data of a chunk in the framebuffer,
+// only the scanlines between y1 and y2 are stored.
+func (f *InputFile) decodeLines(data []byte, y, n, y1, y2 int) error {
+	xMin, xMax := int(f.header.dataWindow[0]), int(f.header.dataWindow[2])
+
+	var err error
+
+	for ; n > 0; n-- {
+		for k := range f.header.channels {
+			ch := &f.header.channels[k]
+
+			if mod(y, int(ch.YSampling)) != 0 {
+				continue
+			}
+
+			pixels := f.framebuffer.find(ch.Name)
+
+			if pixels == nil || y < y1 || y > y2 {
+				data = data[numSamples(int(ch.XSampling), xMin, xMax)*pixelTypeSize(ch.PixelType):]
+				continue
+			}
+
+			data, err = pixels.decodeLine(data, ch.PixelType, y, xMin, xMax)
+
+			if err != nil {
+				return fmt.Errorf("channel %v: %w", ch.Name, err)
+			}
+		}
+
+		y++
+	}
+
+	return nil
+}
+
+// fillChunk fills the scanlines of a chunk which couldn't be read that are between y1 and y2 with the
+// FillValue of each slice, and appends them to missing.
+func (f *InputFile) fillChunk(chunk, y1, y2 int, missing *[]int) error {
+	xMin, yMin := int(f.header.dataWindow[0]), int(f.header.dataWindow[1])
+	xMax, yMax := int(f.header.dataWindow[2]), int(f.header.dataWindow[3])
+	linesPerChunk := linesPerChunk(f.header.compression)
+
+	for y := yMin + chunk*linesPerChunk; y < yMin+(chunk+1)*linesPerChunk && y <= yMax; y++ {
+		if y < y1 || y > y2 {
+			continue
+		}
+
+		for k := range f.header.channels {
+			ch := &f.header.channels[k]
+
+			if pixels := f.framebuffer.find(ch.Name); pixels != nil {
+				if err := pixels.fillLine(y, xMin, xMax); err != nil {
+					return fmt.Errorf("channel %v: %v", ch.Name, err)
+				}
+			}
+		}
+
+		*missing = append(*missing, y)
+	}
+
+	return nil
+}
+
+// readAt reads n bytes from r at offset ofs.
+func readAt(r io.ReaderAt, ofs int64, n int) ([]byte, error) {
+	return readFull(io.NewSectionReader(r, ofs, int64(n)), n)
+}
+
+// seekReaderAt implements io.ReaderAt for an io.ReadSeeker, reads are serialised.
+type seekReaderAt struct {
+	mu sync.Mutex
+	r  io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, ofs int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.r.Seek(ofs, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(s.r, p)
+}
+
+// readSeeker returns r if it can seek, otherwise the whole of r is read into memory.
+func readSeeker(r io.Reader) (io.ReadSeeker, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+
+	buf, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buf), nil
+}
@@ -0,0 +1,109 @@
+package exr
+
+import (
+	"sort"
+	"strings"
+)
+
+// Channels can be grouped into layers by naming them <layer>.<channel>, for example "diffuse.R".  Layers can
+// be nested, "left.specular.B" is the channel B in layer "left.specular".
+
+// SplitChannelName splits a channel name into its layer and the name of the channel within the layer.  The
+// layer is everything before the last period, channels without a layer have an empty layer.
+func SplitChannelName(name string) (layer, channel string) {
+	i := strings.LastIndexByte(name, '.')
+
+	if i < 0 {
+		return "", name
+	}
+
+	return name[:i], name[i+1:]
+}
+
+// JoinChannelName returns the full name of a channel in the given layer.
+func JoinChannelName(layer, channel string) string {
+	if layer == "" {
+		return channel
+	}
+
+	return layer + "." + channel
+}
+
+// Layers returns the names of the layers in the header in alphabetical order.
+func (h *Header) Layers() []string {
+	var layers []string
+
+	for _, ch := range h.channels {
+		layer, _ := SplitChannelName(ch.Name)
+
+		if layer == "" {
+			continue
+		}
+
+		if i := sort.SearchStrings(layers, layer); i == len(layers) || layers[i] != layer {
+			layers = append(layers, "")
+			copy(layers[i+1:], layers[i:])
+			layers[i] = layer
+		}
+	}
+
+	return layers
+}
+
+// LayerChannels returns the channels in the given layer, including those in nested layers.
+func (h *Header) LayerChannels(layer string) []Channel {
+	var channels []Channel
+
+	for _, ch := range h.channels {
+		if strings.HasPrefix(ch.Name, layer+".") {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
+// LayerRGBA returns the full names of the R, G, B and A channels of the layer, empty strings are returned
+// for channels which aren't in the header.
+func (h *Header) LayerRGBA(layer string) (r, g, b, a string) {
+	names := [4]string{}
+
+	for i, c := range []string{"R", "G", "B", "A"} {
+		if name := JoinChannelName(layer, c); h.FindChannel(name) != nil {
+			names[i] = name
+		}
+	}
+
+	return names[0], names[1], names[2], names[3]
+}
+
+// InsertLayer inserts a slice of pixel data for the channel ch in the given layer.
+func (fb *Framebuffer) InsertLayer(layer, ch string, pixels Pixels) {
+	fb.Insert(JoinChannelName(layer, ch), pixels)
+}
+
+// ApplyLayer returns a copy of the framebuffer with every channel moved into the given layer, so that
+// a framebuffer for "R", "G" and "B" can read "diffuse.R", "diffuse.G" and "diffuse.B".
+func (fb Framebuffer) ApplyLayer(layer string) Framebuffer {
+	out := Framebuffer{}
+
+	for _, ch := range fb.channels {
+		out.InsertLayer(layer, ch.name, ch.pixels)
+	}
+
+	return out
+}
+
+// StripLayer returns a copy of the framebuffer containing only the channels in the given layer, with the
+// layer removed from their names.
+func (fb Framebuffer) StripLayer(layer string) Framebuffer {
+	out := Framebuffer{}
+
+	for _, ch := range fb.channels {
+		if name := strings.TrimPrefix(ch.name, layer+"."); name != ch.name {
+			out.Insert(name, ch.pixels)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,92 @@
+package exr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	h := NewHeader(4, 4)
+
+	for _, name := range []string{"R", "G", "diffuse.R", "diffuse.G", "diffuse.B", "left.specular.B", "left.Z"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+	}
+
+	if layers := h.Layers(); !reflect.DeepEqual(layers, []string{"diffuse", "left", "left.specular"}) {
+		t.Fatalf("unexpected layers %v", layers)
+	}
+
+	var names []string
+
+	for _, ch := range h.LayerChannels("left") {
+		names = append(names, ch.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"left.Z", "left.specular.B"}) {
+		t.Fatalf("unexpected channels %v", names)
+	}
+
+	if layer, ch := SplitChannelName("left.specular.B"); layer != "left.specular" || ch != "B" {
+		t.Fatalf("unexpected split %v %v", layer, ch)
+	}
+
+	r, g, b, a := h.LayerRGBA("diffuse")
+
+	if r != "diffuse.R" || g != "diffuse.G" || b != "diffuse.B" || a != "" {
+		t.Fatalf("unexpected RGBA channels %v %v %v %v", r, g, b, a)
+	}
+
+	fb := Framebuffer{}
+	fb.Insert("R", Pixels{})
+	fb.InsertLayer("diffuse", "G", Pixels{})
+
+	if fb := fb.ApplyLayer("beauty"); fb.find("beauty.R") == nil || fb.find("beauty.diffuse.G") == nil {
+		t.Fatalf("expected channels to be moved to layer")
+	}
+
+	if fb := fb.StripLayer("diffuse"); len(fb.channels) != 1 || fb.find("G") == nil {
+		t.Fatalf("expected only stripped channel G")
+	}
+}
+
+func TestRGBALayer(t *testing.T) {
+	h := NewHeader(2, 1)
+	h.AddChannel(Channel{Name: "diffuse.R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+	h.AddChannel(Channel{Name: "diffuse.G", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	rgb := []float32{1, 2, 3, 4}
+
+	fb := Framebuffer{}
+	fb.InsertLayer("diffuse", "R", Pixels{PixelTypeFloat, rgb, 0, 2, 4, 1, 1, 0})
+	fb.InsertLayer("diffuse", "G", Pixels{PixelTypeFloat, rgb, 1, 2, 4, 1, 1, 0})
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(1); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if _, err := NewRGBAInputFile(bytes.NewReader(ws.buf)); err == nil {
+		t.Fatalf("expected error for file without RGBA channels")
+	}
+
+	in, err := NewRGBAInputFileLayer(bytes.NewReader(ws.buf), "diffuse")
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	out := make([]float32, 8)
+	in.SetFramebuffer(out, 0, 4, 8)
+
+	if err := in.ReadPixels(0, 0); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	if expected := []float32{1, 2, 0, 1, 3, 4, 0, 1}; !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v, got %v", expected, out)
+	}
+}
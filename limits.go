@@ -0,0 +1,112 @@
+package exr
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Limits restricts the resources used when reading a file so that corrupt or hostile files are rejected
+// before large allocations are made.  In ReadOptions a zero field takes its value from DefaultLimits and a
+// negative field means no limit.
+type Limits struct {
+	MaxWidth, MaxHeight  int   // Size of the data window
+	MaxPixels            int64 // Pixels in the data window, which bounds the framebuffers callers allocate
+	MaxChannels          int   // Channels in a header
+	MaxAttributeSize     int   // Size in bytes of a single attribute value
+	MaxDecompressedBytes int64 // Total pixel data decompressed by an InputFile, each chunk counts once
+}
+
+// DefaultLimits are used when no ReadOptions are given, they allow any reasonable image of up to 64
+// megapixels, such as 8192x8192.
+var DefaultLimits = Limits{
+	MaxWidth:             1 << 18,
+	MaxHeight:            1 << 18,
+	MaxPixels:            1 << 26,
+	MaxChannels:          1 << 12,
+	MaxAttributeSize:     1 << 26,
+	MaxDecompressedBytes: 1 << 32,
+}
+
+// ReadOptions control how a file is read, the zero ReadOptions reads with DefaultLimits.
+type ReadOptions struct {
+	Limits  Limits
+	Workers int // Goroutines used to decode chunks, if zero then runtime.GOMAXPROCS(0)
+
+	// Progress is called after each chunk read by a call to ReadPixels with the number of chunks done
+	// and the number of chunks the call reads.  Calls are made from the decoding goroutines, one at a time.
+	Progress func(done, total int)
+}
+
+// limits returns the limits of the options with zero fields taken from DefaultLimits.
+func (opts *ReadOptions) limits() Limits {
+	if opts == nil {
+		return DefaultLimits
+	}
+
+	l, d := opts.Limits, DefaultLimits
+
+	if l.MaxWidth == 0 {
+		l.MaxWidth = d.MaxWidth
+	}
+
+	if l.MaxHeight == 0 {
+		l.MaxHeight = d.MaxHeight
+	}
+
+	if l.MaxPixels == 0 {
+		l.MaxPixels = d.MaxPixels
+	}
+
+	if l.MaxChannels == 0 {
+		l.MaxChannels = d.MaxChannels
+	}
+
+	if l.MaxAttributeSize == 0 {
+		l.MaxAttributeSize = d.MaxAttributeSize
+	}
+
+	if l.MaxDecompressedBytes == 0 {
+		l.MaxDecompressedBytes = d.MaxDecompressedBytes
+	}
+
+	return l
+}
+
+// workers returns the number of goroutines to decode chunks with.
+func (opts *ReadOptions) workers() int {
+	if opts == nil || opts.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return opts.Workers
+}
+
+// progressFunc returns the progress callback of the options, nil if opts is nil.
+func (opts *ReadOptions) progressFunc() func(done, total int) {
+	if opts == nil {
+		return nil
+	}
+
+	return opts.Progress
+}
+
+// checkHeader checks the size of the data window of a header, the number of channels is checked as the
+// channel list is read.
+func (l *Limits) checkHeader(h *Header) error {
+	width := int64(h.dataWindow[2]) - int64(h.dataWindow[0]) + 1
+	height := int64(h.dataWindow[3]) - int64(h.dataWindow[1]) + 1
+
+	if l.MaxWidth > 0 && width > int64(l.MaxWidth) {
+		return fmt.Errorf("data window width %v is larger than the limit of %v", width, l.MaxWidth)
+	}
+
+	if l.MaxHeight > 0 && height > int64(l.MaxHeight) {
+		return fmt.Errorf("data window height %v is larger than the limit of %v", height, l.MaxHeight)
+	}
+
+	if l.MaxPixels > 0 && width*height > l.MaxPixels {
+		return fmt.Errorf("data window of %vx%v pixels is larger than the limit of %v pixels", width, height, l.MaxPixels)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package exr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	h := NewHeader(64, 32)
+	h.AddChannel(Channel{Name: "G", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	h.AddChannel(Channel{Name: "R", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+
+	ws := &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, h).WritePixels(32); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	read := func(modify func(l *Limits)) error {
+		opts := &ReadOptions{}
+		modify(&opts.Limits)
+
+		in, err := NewInputFileOptions(bytes.NewReader(ws.buf), opts)
+
+		if err != nil {
+			return err
+		}
+
+		fb, err := AllocateFramebuffer[Half](&h, LayoutPlanar)
+
+		if err != nil {
+			t.Fatalf("error allocating framebuffer: %v", err)
+		}
+
+		in.SetFramebuffer(fb.Framebuffer)
+
+		return in.ReadPixels(0, 31)
+	}
+
+	if err := read(func(l *Limits) {}); err != nil {
+		t.Fatalf("error reading within default limits: %v", err)
+	}
+
+	for _, test := range []struct {
+		modify func(l *Limits)
+		err    string
+	}{
+		{func(l *Limits) { l.MaxWidth = 63 }, "width 64 is larger than the limit"},
+		{func(l *Limits) { l.MaxHeight = 31 }, "height 32 is larger than the limit"},
+		{func(l *Limits) { l.MaxChannels = 1 }, "longer than the limit of 1 channels"},
+		{func(l *Limits) { l.MaxAttributeSize = 16 }, "larger than the limit of 16"},
+		{func(l *Limits) { l.MaxDecompressedBytes = 64 * 2 * 2 * 31 }, "larger than the limit of 7936 bytes"},
+	} {
+		if err := read(test.modify); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+
+	// Chunks read more than once only count once
+	opts := &ReadOptions{}
+	opts.Limits.MaxDecompressedBytes = 64 * 2 * 2 * 32
+
+	in, err := NewInputFileOptions(bytes.NewReader(ws.buf), opts)
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	fb, err := AllocateFramebuffer[Half](&h, LayoutPlanar)
+
+	if err != nil {
+		t.Fatalf("error allocating framebuffer: %v", err)
+	}
+
+	in.SetFramebuffer(fb.Framebuffer)
+
+	for pass := 0; pass < 2; pass++ {
+		for y := 0; y < 32; y++ {
+			if err := in.ReadPixels(y, y); err != nil {
+				t.Fatalf("error reading scanline %v again: %v", y, err)
+			}
+		}
+	}
+
+	// A huge data window is rejected before anything is allocated for it
+	i := bytes.Index(ws.buf, []byte("dataWindow\x00box2i\x00"))
+
+	if i < 0 {
+		t.Fatalf("dataWindow attribute not found")
+	}
+
+	box := ws.buf[i+len("dataWindow\x00box2i\x00")+4:]
+	binary.LittleEndian.PutUint32(box[12:], 1<<30)
+
+	if _, err := NewInputFile(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), "larger than the limit") {
+		t.Fatalf("expected error reading huge data window, got %v", err)
+	}
+
+	// Within the width and height limits but too many pixels for the framebuffers to be allocated
+	binary.LittleEndian.PutUint32(box[8:], 1<<18-1)
+	binary.LittleEndian.PutUint32(box[12:], 1<<18-1)
+
+	const errPixels = "262144x262144 pixels is larger than the limit"
+
+	if _, err := NewInputFile(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), errPixels) {
+		t.Fatalf("expected error reading data window with too many pixels, got %v", err)
+	}
+
+	if _, err := Decode(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), errPixels) {
+		t.Fatalf("expected error decoding data window with too many pixels, got %v", err)
+	}
+
+	if _, _, _, err := LoadRGBA(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), errPixels) {
+		t.Fatalf("expected error loading data window with too many pixels, got %v", err)
+	}
+
+	if _, err := DecodeConfig(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), errPixels) {
+		t.Fatalf("expected error decoding config with too many pixels, got %v", err)
+	}
+
+	// Options which don't set the limits still have the default limits
+	if _, err := NewInputFileOptions(bytes.NewReader(ws.buf), &ReadOptions{Workers: 8}); err == nil || !strings.Contains(err.Error(), errPixels) {
+		t.Fatalf("expected error reading with only workers set, got %v", err)
+	}
+
+	if l := (&ReadOptions{Limits: Limits{MaxPixels: -1}}).limits(); l.MaxPixels != -1 || l.MaxWidth != DefaultLimits.MaxWidth {
+		t.Fatalf("expected negative limit to be kept and zero limits defaulted, got %+v", l)
+	}
+}
+
+func TestLimitsChannels(t *testing.T) {
+	header := func(n int) []byte {
+		// Channels in reverse order
+		var channels Chlist
+
+		for i := n - 1; i >= 0; i-- {
+			channels = append(channels, Channel{Name: fmt.Sprintf("c%05d", i), PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		}
+
+		value, err := channels.MarshalBinary()
+
+		if err != nil {
+			t.Fatalf("error marshalling channels: %v", err)
+		}
+
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+
+		WriteVersion(&EXRVersion{}, w)
+		WriteAttrib(&EXRAttribute{name: "channels", attribType: "chlist", value: value}, w)
+		WriteAttrib(&EXRAttribute{name: "dataWindow", attribType: "box2i", value: make([]byte, 16)}, w)
+		WriteAttrib(nil, w)
+		w.Flush()
+
+		return buf.Bytes()
+	}
+
+	read := func(data []byte, limits Limits) (Header, error) {
+		br := bufio.NewReader(bytes.NewReader(data))
+
+		version, err := ReadVersion(br)
+
+		if err != nil {
+			t.Fatalf("error reading version: %v", err)
+		}
+
+		return readHeader(br, version, limits)
+	}
+
+	// The limit is checked while the list is read
+	limits := DefaultLimits
+	limits.MaxChannels = 10
+
+	if _, err := read(header(40000), limits); err == nil || !strings.Contains(err.Error(), "limit of 10 channels") {
+		t.Fatalf("expected channel limit error, got %v", err)
+	}
+
+	h, err := read(header(DefaultLimits.MaxChannels), DefaultLimits)
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	channels := h.Channels()
+
+	if len(channels) != DefaultLimits.MaxChannels {
+		t.Fatalf("expected %v channels, got %v", DefaultLimits.MaxChannels, len(channels))
+	}
+
+	for i := range channels {
+		if name := fmt.Sprintf("c%05d", i); channels[i].Name != name {
+			t.Fatalf("channel %v is %v, expected %v", i, channels[i].Name, name)
+		}
+	}
+}
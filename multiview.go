@@ -0,0 +1,117 @@
+package exr
+
+import (
+	"strings"
+)
+
+// Multi-view images list their views in the multiView attribute, the first view is the default view.  The
+// channels of a view other than the default have the view name before the final component of the name,
+// "right.R" or "diffuse.right.R", channels of the default view may omit it.  Multi-part files instead store
+// each view in its own part, named by the part's view attribute.
+
+// SetMultiView sets the views of a multi-view image, the first is the default view.
+func (h *Header) SetMultiView(views []string) {
+	h.multiView = append([]string(nil), views...)
+}
+
+// MultiView returns the views of a multi-view image, or nil if the image only has a single view.
+func (h *Header) MultiView() []string {
+	return append([]string(nil), h.multiView...)
+}
+
+// DefaultView returns the default view of a multi-view image, or an empty string.
+func (h *Header) DefaultView() string {
+	if len(h.multiView) == 0 {
+		return ""
+	}
+
+	return h.multiView[0]
+}
+
+// SetView sets the view attribute naming the view stored in a part of a multi-part file.
+func (h *Header) SetView(view string) {
+	h.view = view
+}
+
+// View returns the view attribute of a part of a multi-part file.
+func (h *Header) View() string {
+	return h.view
+}
+
+// ViewFromChannelName returns the view a channel belongs to.  Unprefixed channels such as "R" belong to
+// the default view, an empty string is returned for channels which aren't in a view.
+func ViewFromChannelName(name string, multiView []string) string {
+	if len(multiView) == 0 {
+		return ""
+	}
+
+	s := strings.Split(name, ".")
+
+	if len(s) == 1 {
+		return multiView[0]
+	}
+
+	view := s[len(s)-2]
+
+	for _, v := range multiView {
+		if v == view {
+			return view
+		}
+	}
+
+	return ""
+}
+
+// ViewChannels returns the channels which belong to the given view.
+func (h *Header) ViewChannels(view string) []Channel {
+	var channels []Channel
+
+	for _, ch := range h.channels {
+		if v := ViewFromChannelName(ch.Name, h.multiView); v != "" && v == view {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
+// NonViewChannels returns the channels which don't belong to any view.
+func (h *Header) NonViewChannels() []Channel {
+	var channels []Channel
+
+	for _, ch := range h.channels {
+		if ViewFromChannelName(ch.Name, h.multiView) == "" {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
+// InsertViewName returns the name of a channel in the view multiView[i].  Names in the default view are
+// unchanged, otherwise the view is inserted before the final component, "R" becomes "right.R" and
+// "diffuse.R" becomes "diffuse.right.R".
+func InsertViewName(name string, multiView []string, i int) string {
+	if i == 0 || i >= len(multiView) {
+		return name
+	}
+
+	layer, channel := SplitChannelName(name)
+
+	return JoinChannelName(JoinChannelName(layer, multiView[i]), channel)
+}
+
+// RemoveViewName removes the view from the name of a channel, the inverse of InsertViewName.
+func RemoveViewName(name, view string) string {
+	layer, channel := SplitChannelName(name)
+
+	if layer == view {
+		return channel
+	}
+
+	if strings.HasSuffix(layer, "."+view) {
+		return JoinChannelName(strings.TrimSuffix(layer, "."+view), channel)
+	}
+
+	return name
+}
@@ -0,0 +1,65 @@
+package exr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMultiView(t *testing.T) {
+	views := []string{"left", "right"}
+
+	h := NewHeader(2, 2)
+	h.SetMultiView(views)
+	h.SetView("left")
+
+	for _, name := range []string{"R", "diffuse.R", "right.R", "diffuse.right.R", "Z.extra"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	}
+
+	channelNames := func(channels []Channel) (names []string) {
+		for _, ch := range channels {
+			names = append(names, ch.Name)
+		}
+		return
+	}
+
+	if names := channelNames(h.ViewChannels("left")); !reflect.DeepEqual(names, []string{"R"}) {
+		t.Fatalf("unexpected left channels %v", names)
+	}
+
+	if names := channelNames(h.ViewChannels("right")); !reflect.DeepEqual(names, []string{"diffuse.right.R", "right.R"}) {
+		t.Fatalf("unexpected right channels %v", names)
+	}
+
+	if names := channelNames(h.NonViewChannels()); !reflect.DeepEqual(names, []string{"Z.extra", "diffuse.R"}) {
+		t.Fatalf("unexpected non-view channels %v", names)
+	}
+
+	if name := InsertViewName("diffuse.R", views, 1); name != "diffuse.right.R" {
+		t.Fatalf("unexpected name %v", name)
+	}
+
+	if name := RemoveViewName("diffuse.right.R", "right"); name != "diffuse.R" {
+		t.Fatalf("unexpected name %v", name)
+	}
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+
+	if err := of.WritePixels(2); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	hIn := in.Header()
+
+	if !reflect.DeepEqual(hIn.MultiView(), views) || hIn.DefaultView() != "left" || hIn.View() != "left" {
+		t.Fatalf("unexpected views %v %v %v", hIn.MultiView(), hIn.DefaultView(), hIn.View())
+	}
+}
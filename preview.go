@@ -0,0 +1,176 @@
+package exr
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// SetPreview sets the preview image stored in the header.
+func (h *Header) SetPreview(p Preview) {
+	h.preview = &p
+}
+
+// Preview returns the preview image stored in the header, ok is false if the header has no preview.
+func (h *Header) Preview() (p Preview, ok bool) {
+	if h.preview == nil {
+		return Preview{}, false
+	}
+
+	return *h.preview, true
+}
+
+// Preview returns the preview image stored in the file, ok is false if the file has no preview.
+func (f *InputFile) Preview() (p Preview, ok bool) {
+	return f.header.Preview()
+}
+
+// PreviewOptions control the preview image generated by an OutputFile.
+type PreviewOptions struct {
+	Width    int     // Width of the preview, the height follows the aspect of the data window.  Default 100.
+	Exposure float64 // Exposure adjustment in stops
+	Gamma    float64 // Display gamma.  Default 2.2.
+}
+
+// SetPreviewOptions makes the file generate its preview image from the R, G, B and A (or Y) channels of
+// the framebuffer as the scanlines are written.  It must be called before the first call to WritePixels.
+func (o *OutputFile) SetPreviewOptions(opts PreviewOptions) {
+	if opts.Width <= 0 {
+		opts.Width = 100
+	}
+
+	if opts.Gamma <= 0 {
+		opts.Gamma = 2.2
+	}
+
+	o.previewOptions = &opts
+}
+
+// newPreview returns an empty preview image sized as given by the preview options.
+func (o *OutputFile) newPreview() *Preview {
+	width := int(o.header.dataWindow[2]-o.header.dataWindow[0]) + 1
+	height := int(o.header.dataWindow[3]-o.header.dataWindow[1]) + 1
+
+	w := o.previewOptions.Width
+
+	if w > width {
+		w = width
+	}
+
+	h := int(math.Round(float64(w) * float64(height) / float64(width)))
+
+	if h < 1 {
+		h = 1
+	}
+
+	return &Preview{
+		Width:  uint32(w),
+		Height: uint32(h),
+		Data:   make([]byte, w*h*4),
+	}
+}
+
+// previewByte converts a linear value to an 8 bit display value, values above 1 are compressed with the
+// same knee as OpenEXR's exrmakepreview.
+func previewByte(v float32, m, gamma float64) byte {
+	x := float64(v) * m
+
+	if !(x > 0) {
+		return 0
+	}
+
+	if x > 1 {
+		x = 1 + math.Log((x-1)*0.184874+1)/0.184874
+	}
+
+	return byte(math.Min(math.Pow(x, 1/gamma)*84.66, 255))
+}
+
+// updatePreview fills the rows of the generated preview which are sampled from scanline y.
+func (o *OutputFile) updatePreview(y int) error {
+	p := o.header.preview
+
+	xMin, yMin := int(o.header.dataWindow[0]), int(o.header.dataWindow[1])
+	width := int(o.header.dataWindow[2]) - xMin + 1
+	height := int(o.header.dataWindow[3]) - yMin + 1
+	pw, ph := int(p.Width), int(p.Height)
+
+	// Luminance only images are shown in grey
+	r, g, b := o.framebuffer.find("R"), o.framebuffer.find("G"), o.framebuffer.find("B")
+
+	if lum := o.framebuffer.find("Y"); r == nil && g == nil && b == nil {
+		r, g, b = lum, lum, lum
+	}
+
+	a := o.framebuffer.find("A")
+
+	m := math.Pow(2, math.Max(-20, math.Min(20, o.previewOptions.Exposure+2.47393)))
+
+	value := func(pixels *Pixels, x int) (float32, error) {
+		if pixels == nil {
+			return 0, nil
+		}
+
+		xs, ys := pixels.sampling()
+
+		return pixels.at(x-mod(x, xs), y-mod(y, ys))
+	}
+
+	for py := 0; py < ph; py++ {
+		if yMin+(2*py+1)*height/(2*ph) != y {
+			continue
+		}
+
+		for px := 0; px < pw; px++ {
+			x := xMin + (2*px+1)*width/(2*pw)
+			pixel := p.Data[(py*pw+px)*4:]
+
+			for c, pixels := range []*Pixels{r, g, b} {
+				v, err := value(pixels, x)
+
+				if err != nil {
+					return fmt.Errorf("preview: %v", err)
+				}
+
+				pixel[c] = previewByte(v, m, o.previewOptions.Gamma)
+			}
+
+			pixel[3] = 255
+
+			if a != nil {
+				v, err := value(a, x)
+
+				if err != nil {
+					return fmt.Errorf("preview: %v", err)
+				}
+
+				pixel[3] = 0
+
+				if v > 0 {
+					pixel[3] = byte(math.Min(255, float64(v)*255+0.5))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// writePreview overwrites the preview data in the header with the generated preview.
+func (o *OutputFile) writePreview() error {
+	if _, err := o.w.Seek(o.previewOfs, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to preview: %v", err)
+	}
+
+	if _, err := o.w.Write(o.header.preview.Data); err != nil {
+		return fmt.Errorf("writing preview: %v", err)
+	}
+
+	return nil
+}
+
+// SetPreviewOptions makes the file generate its preview image as the scanlines are written.  Images stored
+// as luminance/chroma have a grey preview.
+func (o *RGBAOutputFile) SetPreviewOptions(opts PreviewOptions) {
+	o.file.SetPreviewOptions(opts)
+}
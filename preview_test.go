@@ -0,0 +1,132 @@
+package exr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	p := Preview{Width: 2, Height: 1, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	b, err := p.MarshalBinary()
+
+	if err != nil {
+		t.Fatalf("error marshalling preview: %v", err)
+	}
+
+	var p2 Preview
+
+	if err := p2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("error unmarshalling preview: %v", err)
+	}
+
+	if !reflect.DeepEqual(p, p2) {
+		t.Fatalf("preview %v does not match %v", p2, p)
+	}
+
+	if err := p2.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Fatalf("expected error unmarshalling truncated preview")
+	}
+
+	// A preview set in the header is written as is
+	h := NewHeader(2, 2)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	h.SetPreview(p)
+
+	ws := &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, h).WritePixels(2); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	if p2, ok := in.Preview(); !ok || !reflect.DeepEqual(p, p2) {
+		t.Fatalf("preview %v does not match %v", p2, p)
+	}
+}
+
+func TestPreviewGenerated(t *testing.T) {
+	width, height := 64, 32
+
+	h := NewHeader(width, height)
+
+	for _, name := range []string{"R", "G", "B", "A"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	}
+
+	data := make([]float32, width*height*4)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := data[(y*width+x)*4:]
+			pixel[0] = float32(x) / float32(width)
+			pixel[1] = float32(y) / float32(height)
+			pixel[2] = 4
+			pixel[3] = 0.5
+		}
+	}
+
+	var fb Framebuffer
+
+	for c, name := range []string{"R", "G", "B", "A"} {
+		InsertSlice(&fb, name, NewInterleavedSlice(data, 0, 0, width, 4, c))
+	}
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+	of.SetPreviewOptions(PreviewOptions{Width: 16, Exposure: 1})
+
+	// Write in two parts so the preview is filled in incrementally
+	if err := of.WritePixels(height / 2); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if err := of.WritePixels(height / 2); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	p, ok := in.Preview()
+
+	if !ok {
+		t.Fatalf("expected file to have a preview")
+	}
+
+	if p.Width != 16 || p.Height != 8 {
+		t.Fatalf("unexpected preview size %vx%v", p.Width, p.Height)
+	}
+
+	m := 5.55 * 2 // 2^(exposure+2.47393)
+
+	for py := 0; py < 8; py++ {
+		for px := 0; px < 16; px++ {
+			x, y := (2*px+1)*width/32, (2*py+1)*height/16
+			src := data[(y*width+x)*4:]
+			pixel := p.Data[(py*16+px)*4:]
+
+			for c := 0; c < 3; c++ {
+				expected := int(previewByte(src[c], m, 2.2))
+
+				if d := int(pixel[c]) - expected; d < -2 || d > 2 {
+					t.Fatalf("preview pixel (%v, %v) component %v is %v, expected %v", px, py, c, pixel[c], expected)
+				}
+			}
+
+			if pixel[3] != 128 {
+				t.Fatalf("preview pixel (%v, %v) has alpha %v, expected 128", px, py, pixel[3])
+			}
+		}
+	}
+}
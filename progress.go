@@ -0,0 +1,26 @@
+package exr
+
+import (
+	"sync"
+)
+
+// progress counts the chunks done by a read or write and reports them to a callback, which may be nil.
+type progress struct {
+	mu    sync.Mutex
+	fn    func(done, total int)
+	done  int
+	total int
+}
+
+// add counts a chunk as done.
+func (p *progress) add() {
+	if p.fn == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.fn(p.done, p.total)
+}
@@ -0,0 +1,120 @@
+package exr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	width, height := 8, 160
+
+	h := NewHeader(width, height)
+	h.SetCompression(CompressionTypeZip)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	data := make([]float32, width*height)
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "Y", NewPlanarSlice(data, 0, 0, width))
+
+	// ZIP has 16 scanlines per chunk so there are 10 chunks
+	var writes []int
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFileOptions(ws, h, &WriteOptions{Progress: func(done, total int) {
+		if total != 10 {
+			t.Errorf("write progress total is %v, expected 10", total)
+		}
+
+		writes = append(writes, done)
+	}})
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if len(writes) != 10 || writes[9] != 10 {
+		t.Fatalf("write progress was %v", writes)
+	}
+
+	for _, workers := range []int{1, 4} {
+		var reads int
+
+		in, err := NewInputFileOptions(bytes.NewReader(ws.buf), &ReadOptions{
+			Workers: workers,
+			Progress: func(done, total int) {
+				reads++
+
+				if done != reads || total != 3 {
+					t.Errorf("read progress is %v of %v, expected %v of 3", done, total, reads)
+				}
+			},
+		})
+
+		if err != nil {
+			t.Fatalf("error reading header: %v", err)
+		}
+
+		in.SetFramebuffer(fb)
+
+		if err := in.ReadPixels(20, 50); err != nil {
+			t.Fatalf("error reading scanlines: %v", err)
+		}
+
+		if reads != 3 {
+			t.Fatalf("%v workers: progress called %v times, expected 3", workers, reads)
+		}
+	}
+
+	// Cancel reading after the third chunk
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in, err := NewInputFileOptions(bytes.NewReader(ws.buf), &ReadOptions{
+		Workers: 1,
+		Progress: func(done, total int) {
+			if done == 3 {
+				cancel()
+			}
+
+			if done > 3 {
+				t.Errorf("chunk %v read after cancel", done)
+			}
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixelsContext(ctx, 0, height-1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if _, err := DecodeContext(ctx, bytes.NewReader(ws.buf), nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled decoding, got %v", err)
+	}
+
+	// Cancel writing after the second chunk
+	ctx, cancel = context.WithCancel(context.Background())
+
+	of = NewOutputFileOptions(&writeSeekBuffer{}, h, &WriteOptions{Workers: 1, Progress: func(done, total int) {
+		if done == 2 {
+			cancel()
+		}
+
+		if done > 2 {
+			t.Errorf("chunk %v written after cancel", done)
+		}
+	}})
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixelsContext(ctx, height); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled writing, got %v", err)
+	}
+}
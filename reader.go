@@ -2,45 +2,38 @@ package exr
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"sort"
 )
 
 func ReadVersion(r *bufio.Reader) (*EXRVersion, error) {
 	var magic [4]byte
 
-	n, err := r.Read(magic[:])
-
-	if err != nil {
-		return nil, fmt.Errorf("error reading magic: %v", err)
-	}
-
-	if n != 4 {
-		return nil, err
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("error reading magic: %w", truncated(err))
 	}
 
 	if magic[0] != 0x76 ||
 		magic[1] != 0x2f ||
 		magic[2] != 0x31 ||
 		magic[3] != 0x01 {
-		return nil, fmt.Errorf("incorrect magic: %v (%v)", magic, string(magic[:]))
+		return nil, fmt.Errorf("%w: incorrect magic: %v (%v)", ErrNotEXR, magic, string(magic[:]))
 	}
 
 	var versionBuf [4]byte
 
-	n, err = r.Read(versionBuf[:])
-
-	if err != nil {
-		return nil, err
-	}
-
-	if n != 4 {
-		return nil, err
+	if _, err := io.ReadFull(r, versionBuf[:]); err != nil {
+		return nil, fmt.Errorf("error reading version: %w", truncated(err))
 	}
 
 	version := (int)(versionBuf[3])<<24 | (int)(versionBuf[2])<<16 | (int)(versionBuf[1])<<8 | (int)(versionBuf[0])
 
 	if version&0xff != 2 {
-		return nil, fmt.Errorf("version != 2 (%v)", version)
+		return nil, fmt.Errorf("%w: version != 2 (%v)", ErrUnsupportedVersion, version)
 	}
 
 	v := &EXRVersion{}
@@ -51,7 +44,7 @@ func ReadVersion(r *bufio.Reader) (*EXRVersion, error) {
 		// Old version 'tiled' bit
 		if version&((1<<11)|(1<<12)) != 0 {
 			// error
-			return nil, fmt.Errorf("incompatible tiled bit (%v)", version)
+			return nil, fmt.Errorf("%w: incompatible tiled bit (%v)", ErrUnsupportedVersion, version)
 		}
 
 		v.tiled = true
@@ -73,51 +66,74 @@ func ReadVersion(r *bufio.Reader) (*EXRVersion, error) {
 
 }
 
+// readName reads a null-terminated name of at most maxLength bytes.
+func readName(r *bufio.Reader, maxLength int) (string, error) {
+	var buf []byte
+
+	for {
+		c, err := r.ReadByte()
+
+		if err != nil {
+			return "", truncated(err)
+		}
+
+		if c == 0x00 {
+			return string(buf), nil
+		}
+
+		if len(buf) == maxLength {
+			return "", fmt.Errorf("name %q... is longer than %v bytes", buf, maxLength)
+		}
+
+		buf = append(buf, c)
+	}
+}
+
+// ReadAttrib reads the next attribute in a header, nil is returned at the end of the header.
 func ReadAttrib(r *bufio.Reader) (*EXRAttribute, error) {
-	buf, err := r.ReadBytes(0x00)
+	return readAttrib(r, maxLongNameLength, 0)
+}
+
+// readAttrib reads the next attribute in a header, names and types may be up to maxNameLength bytes and
+// values up to maxSize bytes unless it is zero.
+func readAttrib(r *bufio.Reader, maxNameLength, maxSize int) (*EXRAttribute, error) {
+	name, err := readName(r, maxNameLength)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(buf) == 1 {
+	if name == "" {
 		// We've only read the single null byte so this is the last attribute in header
 		return nil, nil
 	}
 
-	name := string(buf[:len(buf)-1])
-
-	buf, err = r.ReadBytes(0x00)
+	attribType, err := readName(r, maxNameLength)
 
 	if err != nil {
 		return nil, err
 	}
 
-	attribType := string(buf[:len(buf)-1])
-
 	var size [4]byte
 
-	n, err := r.Read(size[:])
-
-	if err != nil {
-		return nil, err
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, fmt.Errorf("attribute %v: reading size: %w", name, truncated(err))
 	}
 
-	if n != 4 {
-		return nil, err
+	dataSize := int(int32(binary.LittleEndian.Uint32(size[:])))
+
+	if dataSize < 0 {
+		return nil, fmt.Errorf("attribute %v has invalid size %v", name, dataSize)
 	}
 
-	dataSize := (int)(size[3])<<24 | (int)(size[2])<<16 | (int)(size[1])<<8 | (int)(size[0])
-	data := make([]byte, dataSize)
+	if maxSize > 0 && dataSize > maxSize {
+		return nil, fmt.Errorf("attribute %v has size %v, larger than the limit of %v", name, dataSize, maxSize)
+	}
 
-	n, err = r.Read(data[:])
+	data, err := readFull(r, dataSize)
 
 	if err != nil {
-		return nil, err
-	}
-
-	if n != dataSize {
-		return nil, err
+		return nil, fmt.Errorf("attribute %v: reading %v bytes: %w", name, dataSize, truncated(err))
 	}
 
 	return &EXRAttribute{
@@ -127,78 +143,232 @@ func ReadAttrib(r *bufio.Reader) (*EXRAttribute, error) {
 }
 
 func ReadChlist(r *bufio.Reader) ([]*EXRChannelInfo, error) {
+	return readChlist(r, 0)
+}
+
+// readChlist reads a channel list of at most maxChannels channels unless it is zero.
+func readChlist(r *bufio.Reader, maxChannels int) ([]*EXRChannelInfo, error) {
 	var channels []*EXRChannelInfo
 
 	for {
-		buf, err := r.ReadBytes(0x00)
+		name, err := readName(r, maxLongNameLength)
 
 		if err != nil {
 			return nil, err
 		}
 
-		if len(buf) == 1 {
+		if name == "" {
 			// We've only read the single null byte so this is the last channel in attribute
 			return channels, nil
 		}
 
-		name := string(buf[:len(buf)-1])
-
-		var intbuf [4]byte
+		if maxChannels > 0 && len(channels) == maxChannels {
+			return nil, fmt.Errorf("channel list is longer than the limit of %v channels", maxChannels)
+		}
 
-		n, err := r.Read(intbuf[:])
+		var intbuf [16]byte
 
-		if err != nil {
-			return nil, err
+		if _, err := io.ReadFull(r, intbuf[:]); err != nil {
+			return nil, fmt.Errorf("channel %v: %w", name, truncated(err))
 		}
 
-		if n != 4 {
-			return nil, err
-		}
+		pixelType := int(int32(binary.LittleEndian.Uint32(intbuf[0:])))
+		pLinear := intbuf[4] != 0
+		xSampling := int(int32(binary.LittleEndian.Uint32(intbuf[8:])))
+		ySampling := int(int32(binary.LittleEndian.Uint32(intbuf[12:])))
 
-		pixelType := int(intbuf[0])
+		channels = append(channels, &EXRChannelInfo{
+			name:      name,
+			pixelType: pixelType,
+			pLinear:   pLinear,
+			xSampling: xSampling,
+			ySampling: ySampling,
+		})
+	}
+}
 
-		n, err = r.Read(intbuf[:])
+// readFullChunkSize is the largest buffer readFull allocates before it has seen the data.
+const readFullChunkSize = 1 << 20
 
-		if err != nil {
-			return nil, err
-		}
+// readFull reads exactly n bytes from r.  Large sizes are read incrementally so that a corrupt size in a
+// truncated file can't cause a huge allocation.
+func readFull(r io.Reader, n int) ([]byte, error) {
+	if n <= readFullChunkSize {
+		buf := make([]byte, n)
 
-		if n != 4 {
+		if _, err := io.ReadFull(r, buf); err != nil {
 			return nil, err
 		}
 
-		pLinear := int(intbuf[0]) != 0
+		return buf, nil
+	}
 
-		n, err = r.Read(intbuf[:])
+	buf := bytes.Buffer{}
 
-		if err != nil {
-			return nil, err
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
 
-		if n != 4 {
-			return nil, err
-		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readHeader reads the attributes of a single header and interprets the standard attributes.  Names are
+// limited to 31 bytes unless the version has the long name flag set.  The header must be within limits.
+func readHeader(r *bufio.Reader, version *EXRVersion, limits Limits) (Header, error) {
+	h := Header{pixelAspectRatio: 1, screenWindowWidth: 1}
+
+	maxNameLength := maxShortNameLength
 
-		xSampling := (int)(intbuf[3])<<24 | (int)(intbuf[2])<<16 | (int)(intbuf[1])<<8 | (int)(intbuf[0])
+	if version.longName {
+		maxNameLength = maxLongNameLength
+	}
+
+	haveChannels, haveDataWindow := false, false
 
-		n, err = r.Read(intbuf[:])
+	for {
+		attrib, err := readAttrib(r, maxNameLength, limits.MaxAttributeSize)
 
 		if err != nil {
-			return nil, err
+			return h, fmt.Errorf("error reading attribute: %w", err)
 		}
 
-		if n != 4 {
-			return nil, err
+		if attrib == nil {
+			// end of header
+			break
 		}
 
-		ySampling := (int)(intbuf[3])<<24 | (int)(intbuf[2])<<16 | (int)(intbuf[1])<<8 | (int)(intbuf[0])
+		switch attrib.name {
+		case "channels":
+			var channels Chlist
+
+			if err := channels.unmarshalBinary(attrib.value, limits.MaxChannels); err != nil {
+				return h, err
+			}
+
+			for _, ch := range channels {
+				if len(ch.Name) > maxNameLength {
+					return h, fmt.Errorf("channel name %q is longer than %v bytes", ch.Name, maxNameLength)
+				}
+			}
+
+			// Sorted once rather than with AddChannel as the list may be long
+			h.channels = append(h.channels, channels...)
+			sort.SliceStable(h.channels, func(i, j int) bool { return h.channels[i].Name < h.channels[j].Name })
+
+			haveChannels = true
+		case "acesImageContainerFlag":
+			if len(attrib.value) != 4 {
+				return h, fmt.Errorf("acesImageContainerFlag attribute has size %v, expected 4", len(attrib.value))
+			}
+
+			h.acesContainer = binary.LittleEndian.Uint32(attrib.value) == 1
+		case "adoptedNeutral":
+			if len(attrib.value) != 8 {
+				return h, fmt.Errorf("adoptedNeutral attribute has size %v, expected 8", len(attrib.value))
+			}
+
+			h.adoptedNeutral = &V2f{
+				math.Float32frombits(binary.LittleEndian.Uint32(attrib.value[0:])),
+				math.Float32frombits(binary.LittleEndian.Uint32(attrib.value[4:])),
+			}
+		case "chromaticities":
+			var c Chromaticities
+
+			if err := c.UnmarshalBinary(attrib.value); err != nil {
+				return h, err
+			}
+
+			h.SetChromaticities(c)
+		case "compression":
+			if len(attrib.value) != 1 {
+				return h, fmt.Errorf("compression attribute has size %v, expected 1", len(attrib.value))
+			}
+
+			h.compression = int(attrib.value[0])
+		case "dataWindow", "displayWindow":
+			var b Box2i
+
+			if err := b.UnmarshalBinary(attrib.value); err != nil {
+				return h, fmt.Errorf("%v: %v", attrib.name, err)
+			}
+
+			if attrib.name == "dataWindow" {
+				h.dataWindow = [4]int32{b.xMin, b.yMin, b.xMax, b.yMax}
+				haveDataWindow = true
+			} else {
+				h.displayWindow = [4]int32{b.xMin, b.yMin, b.xMax, b.yMax}
+			}
+		case "lineOrder":
+			if len(attrib.value) != 1 {
+				return h, fmt.Errorf("lineOrder attribute has size %v, expected 1", len(attrib.value))
+			}
+
+			h.lineOrder = int(attrib.value[0])
+		case "pixelAspectRatio", "screenWindowWidth":
+			if len(attrib.value) != 4 {
+				return h, fmt.Errorf("%v attribute has size %v, expected 4", attrib.name, len(attrib.value))
+			}
+
+			v := math.Float32frombits(binary.LittleEndian.Uint32(attrib.value))
+
+			if attrib.name == "pixelAspectRatio" {
+				h.pixelAspectRatio = v
+			} else {
+				h.screenWindowWidth = v
+			}
+		case "screenWindowCenter":
+			if len(attrib.value) != 8 {
+				return h, fmt.Errorf("screenWindowCenter attribute has size %v, expected 8", len(attrib.value))
+			}
+
+			h.screenWindowCenter = V2f{
+				math.Float32frombits(binary.LittleEndian.Uint32(attrib.value[0:])),
+				math.Float32frombits(binary.LittleEndian.Uint32(attrib.value[4:])),
+			}
+		case "preview":
+			var p Preview
+
+			if err := p.UnmarshalBinary(attrib.value); err != nil {
+				return h, err
+			}
+
+			h.preview = &p
+		case "multiView":
+			var views Stringvector
+
+			if err := views.UnmarshalBinary(attrib.value); err != nil {
+				return h, err
+			}
+
+			h.multiView = views
+		case "view":
+			h.view = string(attrib.value)
+		case "tiles":
+			var td TileDesc
+
+			if err := binary.Read(bytes.NewReader(attrib.value), binary.LittleEndian, &td); err != nil {
+				return h, fmt.Errorf("tiles: %v", err)
+			}
+
+			h.SetTileDescription(TileDescription{Width: int(td.XSize), Height: int(td.YSize), Kind: int(td.Mode & 0x0f)})
+		}
+	}
 
-		channels = append(channels, &EXRChannelInfo{
-			name:      name,
-			pixelType: pixelType,
-			pLinear:   pLinear,
-			xSampling: xSampling,
-			ySampling: ySampling,
-		})
+	if !haveChannels {
+		return h, fmt.Errorf("header is missing the channels attribute")
 	}
+
+	if !haveDataWindow {
+		return h, fmt.Errorf("header is missing the dataWindow attribute")
+	}
+
+	if err := limits.checkHeader(&h); err != nil {
+		return h, err
+	}
+
+	return h, nil
 }
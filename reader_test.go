@@ -3,7 +3,10 @@ package exr
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"io"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -102,3 +105,303 @@ func TestLoader(t *testing.T) {
 		})
 	}
 }
+
+func TestInputFile(t *testing.T) {
+	f, err := os.Open("testdata/asakusa.exr")
+
+	if err != nil {
+		t.Fatalf("Error loading testdata: %v", err)
+	}
+
+	defer f.Close()
+
+	in, err := NewInputFile(f)
+
+	if err != nil {
+		t.Fatalf("Error reading header: %v", err)
+	}
+
+	h := in.Header()
+	xMin, yMin, xMax, yMax := h.DataWindow()
+	width, height := int(xMax-xMin+1), int(yMax-yMin+1)
+
+	r := make([]float32, width*height)
+
+	fb := Framebuffer{}
+	fb.Insert("R", Pixels{PixelTypeFloat, r, 0, 1, int32(width), 1, 1, 0})
+	fb.Insert("Z", Pixels{PixelTypeFloat, make([]float32, width*height), 0, 1, int32(width), 1, 1, 0.5})
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixels(int(yMin), int(yMax)); err != nil {
+		t.Fatalf("Error reading scanlines: %v", err)
+	}
+
+	var sum float32
+
+	for _, v := range r {
+		sum += v
+	}
+
+	if sum == 0 {
+		t.Fatalf("expected non-zero pixel data")
+	}
+}
+
+// readerAt only implements io.ReaderAt, as a client fetching ranges from a remote store might.
+type readerAt struct {
+	data []byte
+}
+
+func (r readerAt) ReadAt(p []byte, ofs int64) (int, error) {
+	return bytes.NewReader(r.data).ReadAt(p, ofs)
+}
+
+// seekerOnly hides the io.ReaderAt implementation of a bytes.Reader.
+type seekerOnly struct {
+	io.ReadSeeker
+}
+
+func TestInputFileReaderAt(t *testing.T) {
+	width, height := 16, 256
+
+	h := NewHeader(width, height)
+	h.SetCompression(CompressionTypeZip)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	data := make([]float32, width*height)
+
+	for i := range data {
+		data[i] = float32(i)
+	}
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "Y", NewPlanarSlice(data, 0, 0, width))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	ra, err := NewInputFileReaderAt(readerAt{ws.buf}, int64(len(ws.buf)), nil)
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	rs, err := NewInputFile(seekerOnly{bytes.NewReader(ws.buf)})
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	for _, in := range []*InputFile{ra, rs} {
+		out := make([]float32, width*height)
+
+		var fb Framebuffer
+
+		InsertSlice(&fb, "Y", NewPlanarSlice(out, 0, 0, width))
+		in.SetFramebuffer(fb)
+
+		// Read blocks of scanlines concurrently
+		errs := make(chan error, height/32)
+
+		for y := 0; y < height; y += 32 {
+			go func(y int) {
+				errs <- in.ReadPixels(y, y+31)
+			}(y)
+		}
+
+		for y := 0; y < height; y += 32 {
+			if err := <-errs; err != nil {
+				t.Fatalf("error reading scanlines: %v", err)
+			}
+		}
+
+		if !reflect.DeepEqual(out, data) {
+			t.Fatalf("pixels read concurrently don't match")
+		}
+	}
+}
+
+// fuzzSeeds returns small files written with each supported compression.
+func fuzzSeeds(t testing.TB) [][]byte {
+	var seeds [][]byte
+
+	for _, compression := range []int{CompressionTypeNone, CompressionTypeRLE, CompressionTypeZipS, CompressionTypeZip} {
+		h := NewHeaderWindow(-2, 2, 5, 9)
+		h.SetCompression(compression)
+		h.SetPreview(Preview{Width: 1, Height: 1, Data: []byte{1, 2, 3, 4}})
+		h.SetMultiView([]string{"left", "right"})
+		h.AddChannel(Channel{Name: "G", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+		h.AddChannel(Channel{Name: "R", PixelType: PixelTypeUInt, XSampling: 1, YSampling: 1})
+		h.AddChannel(Channel{Name: "RY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+
+		data := make([]float32, 8*8)
+
+		for i := range data {
+			data[i] = float32(i % 5)
+		}
+
+		var fb Framebuffer
+
+		for _, name := range []string{"G", "R"} {
+			InsertSlice(&fb, name, NewPlanarSlice(data, -2, 2, 8))
+		}
+
+		ws := &writeSeekBuffer{}
+		of := NewOutputFile(ws, h)
+		of.SetFramebuffer(fb)
+
+		if err := of.WritePixels(8); err != nil {
+			t.Fatalf("error writing seed: %v", err)
+		}
+
+		seeds = append(seeds, ws.buf)
+	}
+
+	return seeds
+}
+
+func FuzzReadHeader(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		br := bufio.NewReader(bytes.NewReader(data))
+
+		version, err := ReadVersion(br)
+
+		if err != nil {
+			return
+		}
+
+		h, err := readHeader(br, version, DefaultLimits)
+
+		if err != nil {
+			return
+		}
+
+		h.Validate()
+	})
+}
+
+func FuzzReadPixels(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in, err := NewInputFile(bytes.NewReader(data))
+
+		if err != nil {
+			return
+		}
+
+		h := in.Header()
+		xMin, yMin, xMax, yMax := h.DataWindow()
+
+		// Keep the framebuffer allocated by the test small
+		if int64(xMax-xMin+1)*int64(yMax-yMin+1) > 1<<12 {
+			return
+		}
+
+		fb, err := AllocateFramebuffer[float32](&h, LayoutPlanar)
+
+		if err != nil {
+			return
+		}
+
+		in.SetFramebuffer(fb.Framebuffer)
+		in.ReadPixels(int(yMin), int(yMax))
+	})
+}
+
+func TestInputFileWorkers(t *testing.T) {
+	width, height := 8, 200
+
+	h := NewHeader(width, height)
+	h.SetCompression(CompressionTypeZip)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	data := make([]float32, width*height)
+
+	for i := range data {
+		data[i] = float32(i)
+	}
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "Y", NewPlanarSlice(data, 0, 0, width))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	// Corrupt the y coordinate of two chunks
+	corrupt := append([]byte(nil), ws.buf...)
+
+	for _, chunk := range []int{3, 9} {
+		corrupt[of.offsetTable[chunk]] ^= 0xff
+	}
+
+	read := func(b []byte, workers int) ([]float32, error) {
+		in, err := NewInputFileOptions(bytes.NewReader(b), &ReadOptions{Workers: workers})
+
+		if err != nil {
+			t.Fatalf("error reading header: %v", err)
+		}
+
+		out := make([]float32, width*height)
+
+		var fb Framebuffer
+
+		s := NewPlanarSlice(out, 0, 0, width)
+		s.FillValue = -1
+		InsertSlice(&fb, "Y", s)
+
+		in.SetFramebuffer(fb)
+
+		return out, in.ReadPixels(5, height-1)
+	}
+
+	want, err := read(ws.buf, 1)
+
+	if err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	wantCorrupt, wantErr := read(corrupt, 1)
+
+	var incomplete *IncompleteError
+
+	if !errors.As(wantErr, &incomplete) || len(incomplete.Errs) != 2 {
+		t.Fatalf("expected an IncompleteError for 2 chunks, got %v", wantErr)
+	}
+
+	for _, workers := range []int{0, 2, 4, 32} {
+		out, err := read(ws.buf, workers)
+
+		if err != nil {
+			t.Fatalf("%v workers: error reading scanlines: %v", workers, err)
+		}
+
+		if !reflect.DeepEqual(out, want) {
+			t.Fatalf("%v workers: pixels don't match", workers)
+		}
+
+		out, err = read(corrupt, workers)
+
+		if !reflect.DeepEqual(err, wantErr) || !reflect.DeepEqual(out, wantCorrupt) {
+			t.Fatalf("%v workers: reading corrupt file gave %v, expected %v", workers, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package exr
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Files from writers which stopped before finishing can have an offset table which is zero or only
+// partly filled in.  As OpenEXR does, the table is then rebuilt by scanning the chunks which follow it,
+// so the scanlines which were written can still be read.
+
+// checkOffsetTable rebuilds the offset table if any offset is outside the file, tableEnd is the file
+// offset just after the table.
+func (f *InputFile) checkOffsetTable(tableEnd int64) {
+	for _, ofs := range f.offsetTable {
+		if ofs < uint64(tableEnd) || ofs > uint64(f.size-8) {
+			f.reconstructOffsetTable(tableEnd, f.size)
+			return
+		}
+	}
+}
+
+// reconstructOffsetTable scans the chunks from the end of the offset table, until the end of the file or
+// a chunk header which isn't valid, and records the offset of each.  Chunks which aren't found are left
+// with an offset of zero.
+func (f *InputFile) reconstructOffsetTable(tableEnd, size int64) {
+	yMin, yMax := int(f.header.dataWindow[1]), int(f.header.dataWindow[3])
+	linesPerChunk := linesPerChunk(f.header.compression)
+
+	for i := range f.offsetTable {
+		f.offsetTable[i] = 0
+	}
+
+	for ofs := tableEnd; ofs+8 <= size; {
+		chunkHeader, err := readAt(f.r, ofs, 8)
+
+		if err != nil {
+			return
+		}
+
+		y := int(int32(binary.LittleEndian.Uint32(chunkHeader[0:])))
+		dataSize := int64(int32(binary.LittleEndian.Uint32(chunkHeader[4:])))
+
+		if y < yMin || y > yMax || (y-yMin)%linesPerChunk != 0 {
+			return
+		}
+
+		n := linesPerChunk
+
+		if y+n-1 > yMax {
+			n = yMax - y + 1
+		}
+
+		if dataSize < 0 || dataSize > int64(f.header.chunkSize(y, n)) || ofs+8+dataSize > size {
+			return
+		}
+
+		if chunk := (y - yMin) / linesPerChunk; f.offsetTable[chunk] == 0 {
+			f.offsetTable[chunk] = uint64(ofs)
+		}
+
+		ofs += 8 + dataSize
+	}
+}
+
+// IsComplete returns true if every chunk of pixel data was found in the file.  Files which are not
+// complete can still be read, the missing scanlines are reported by ReadPixels.
+func (f *InputFile) IsComplete() bool {
+	for _, ofs := range f.offsetTable {
+		if ofs == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IncompleteError is returned by ReadPixels when some scanlines are missing from the file or could not be
+// decoded.  Every other scanline is read and the missing ones are filled with the FillValue of each slice.
+type IncompleteError struct {
+	Missing []int   // Missing scanlines in increasing order
+	Errs    []error // A ChunkError for each chunk which couldn't be read
+}
+
+func (e *IncompleteError) Error() string {
+	if len(e.Errs) == 0 {
+		return fmt.Sprintf("%v scanlines are missing", len(e.Missing))
+	}
+
+	return fmt.Sprintf("%v scanlines are missing, %v", len(e.Missing), e.Errs[0])
+}
+
+func (e *IncompleteError) Unwrap() []error {
+	return e.Errs
+}
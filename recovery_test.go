@@ -0,0 +1,130 @@
+package exr
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	width, height := 8, 40
+
+	h := NewHeader(width, height)
+	h.SetCompression(CompressionTypeZip)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	data := make([]float32, width*height)
+
+	for i := range data {
+		data[i] = float32(i)
+	}
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "Y", NewPlanarSlice(data, 0, 0, width))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	// ZIP has 16 scanlines per chunk so there are 3 chunks, zero the offset table as if writing had stopped
+	tableEnd := int(of.offsetTableOfs) + 3*8
+	firstChunk := int(of.offsetTable[0])
+	lastChunk := int(of.offsetTable[2])
+
+	read := func(b []byte) (*InputFile, []float32, error) {
+		in, err := NewInputFile(bytes.NewReader(b))
+
+		if err != nil {
+			t.Fatalf("error reading file: %v", err)
+		}
+
+		out := make([]float32, width*height)
+
+		var fb Framebuffer
+
+		s := NewPlanarSlice(out, 0, 0, width)
+		s.FillValue = -1
+		InsertSlice(&fb, "Y", s)
+
+		in.SetFramebuffer(fb)
+
+		return in, out, in.ReadPixels(0, height-1)
+	}
+
+	buf := append([]byte(nil), ws.buf...)
+
+	for i := int(of.offsetTableOfs); i < tableEnd; i++ {
+		buf[i] = 0
+	}
+
+	in, out, err := read(buf)
+
+	if err != nil {
+		t.Fatalf("error reading pixels with rebuilt offset table: %v", err)
+	}
+
+	if !in.IsComplete() || !reflect.DeepEqual(out, data) {
+		t.Fatalf("pixels read with rebuilt offset table don't match")
+	}
+
+	// Cut the file in the last chunk
+	in, out, err = read(buf[:lastChunk+10])
+
+	var incomplete *IncompleteError
+
+	if !errors.As(err, &incomplete) || !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected incomplete error, got %v", err)
+	}
+
+	if in.IsComplete() {
+		t.Fatalf("expected file to be incomplete")
+	}
+
+	if len(incomplete.Missing) != 8 || incomplete.Missing[0] != 32 || incomplete.Missing[7] != 39 {
+		t.Fatalf("unexpected missing scanlines %v", incomplete.Missing)
+	}
+
+	// An error built without chunk errors can still be formatted
+	if msg := (&IncompleteError{Missing: []int{1, 2}}).Error(); msg != "2 scanlines are missing" {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+
+	for i := range out {
+		expected := data[i]
+
+		if i >= 32*width {
+			expected = -1
+		}
+
+		if out[i] != expected {
+			t.Fatalf("pixel %v is %v, expected %v", i, out[i], expected)
+		}
+	}
+
+	// A corrupt chunk is skipped
+	buf = append([]byte(nil), ws.buf...)
+	buf[firstChunk+8+4] ^= 0xff
+
+	_, out, err = read(buf)
+
+	if !errors.As(err, &incomplete) || !errors.Is(err, ErrCorruptChunk) || len(incomplete.Missing) != 16 {
+		t.Fatalf("expected corrupt chunk error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(out[16*width:], data[16*width:]) {
+		t.Fatalf("pixels after corrupt chunk don't match")
+	}
+
+	// Partial images can still be decoded
+	img, err := Decode(bytes.NewReader(ws.buf[:lastChunk]))
+
+	if !errors.As(err, &incomplete) || img == nil {
+		t.Fatalf("expected partial image and incomplete error, got %v", err)
+	}
+}
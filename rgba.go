@@ -0,0 +1,660 @@
+package exr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+)
+
+// RGBAChannels describes which of the channels of an RGBA image are stored in a file.  Luminance/chroma
+// files store Y at full resolution and the chroma channels RY and BY sub-sampled by 2 in x and y.
+type RGBAChannels int
+
+const (
+	WriteR RGBAChannels = 0x01 // Red
+	WriteG RGBAChannels = 0x02 // Green
+	WriteB RGBAChannels = 0x04 // Blue
+	WriteA RGBAChannels = 0x08 // Alpha
+	WriteY RGBAChannels = 0x10 // Luminance, for black and white images or in combination with chroma
+	WriteC RGBAChannels = 0x20 // Chroma, the two sub-sampled channels RY and BY (scanline images only)
+
+	WriteRGB  = WriteR | WriteG | WriteB
+	WriteRGBA = WriteR | WriteG | WriteB | WriteA
+	WriteYC   = WriteY | WriteC
+	WriteYA   = WriteY | WriteA
+	WriteYCA  = WriteY | WriteC | WriteA
+)
+
+// halfMax is the largest finite half value.
+const halfMax = 65504
+
+// Filter taps for chroma sub-sampling from OpenEXR's ImfRgbaYca.cpp, applied to the samples at offsets
+// -13, -11 ... -1 and (mirrored) 1 ... 11, 13.
+var decimateTaps = [...]float32{0.001064, -0.003771, 0.009801, -0.021586, 0.043978, -0.093067, 0.313659}
+
+const decimateCenter = 0.499846
+
+var reconstructTaps = [...]float32{0.002128, -0.007540, 0.019597, -0.043159, 0.087929, -0.186077, 0.627123}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+
+	if v > max {
+		return max
+	}
+
+	return v
+}
+
+// decimateChroma low-pass filters the n samples returned by get and stores every second sample using set,
+// halving the resolution of a chroma channel.  Samples beyond the edges repeat the edge sample.
+func decimateChroma(n int, get func(i int) float32, set func(i int, v float32)) {
+	for i := 0; i < n; i += 2 {
+		v := decimateCenter * get(i)
+
+		for k, w := range decimateTaps {
+			ofs := 13 - 2*k
+			v += w * (get(clampInt(i-ofs, 0, n-1)) + get(clampInt(i+ofs, 0, n-1)))
+		}
+
+		set(i/2, v)
+	}
+}
+
+// reconstructChroma interpolates a chroma channel of n samples from the (n+1)/2 sub-sampled values returned
+// by get, storing the full resolution samples using set.
+func reconstructChroma(n int, get func(i int) float32, set func(i int, v float32)) {
+	m := (n + 1) / 2
+
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			set(i, get(i/2))
+			continue
+		}
+
+		var v float32
+
+		for k, w := range reconstructTaps {
+			ofs := 13 - 2*k
+			v += w * (get(clampInt((i-ofs)/2, 0, m-1)) + get(clampInt((i+ofs)/2, 0, m-1)))
+		}
+
+		set(i, v)
+	}
+}
+
+// rgbToYCA converts RGB to luminance and the chroma differences (R-Y)/Y and (B-Y)/Y.
+func rgbToYCA(yw [3]float32, r, g, b float32) (y, ry, by float32) {
+	if r == g && g == b {
+		// Grey pixels have no chroma
+		return g, 0, 0
+	}
+
+	y = r*yw[0] + g*yw[1] + b*yw[2]
+
+	if float32(math.Abs(float64(r-y))) < halfMax*y {
+		ry = (r - y) / y
+	}
+
+	if float32(math.Abs(float64(b-y))) < halfMax*y {
+		by = (b - y) / y
+	}
+
+	return
+}
+
+// ycaToRGB is the inverse of rgbToYCA.
+func ycaToRGB(yw [3]float32, y, ry, by float32) (r, g, b float32) {
+	if ry == 0 && by == 0 {
+		return y, y, y
+	}
+
+	r = (ry + 1) * y
+	b = (by + 1) * y
+	g = (y - r*yw[0] - b*yw[2]) / yw[1]
+
+	return
+}
+
+// rgbaChannels returns the RGBA channels of the given layer present in the header.
+func rgbaChannels(h *Header, layer string) RGBAChannels {
+	var channels RGBAChannels
+
+	for _, c := range []struct {
+		name string
+		bit  RGBAChannels
+	}{{"R", WriteR}, {"G", WriteG}, {"B", WriteB}, {"A", WriteA}, {"Y", WriteY}, {"RY", WriteC}, {"BY", WriteC}} {
+		if h.FindChannel(JoinChannelName(layer, c.name)) != nil {
+			channels |= c.bit
+		}
+	}
+
+	return channels
+}
+
+// RGBAOutputFile writes an image from interleaved RGBA float32 pixels, either as RGBA channels or as
+// luminance and sub-sampled chroma.
+type RGBAOutputFile struct {
+	file     *OutputFile
+	channels RGBAChannels
+	yw       [3]float32
+
+	pixels                 []float32
+	base, xStride, yStride int32
+
+	converted bool
+}
+
+// NewRGBAOutputFile creates a file with the given RGBA channels, any channels already in the header are
+// replaced.  Luminance weights are derived from the chromaticities in the header.
+func NewRGBAOutputFile(w io.WriteSeeker, h Header, channels RGBAChannels) *RGBAOutputFile {
+	return newRGBAOutputFile(w, h, channels, PixelTypeHalf)
+}
+
+// newRGBAOutputFile creates a file with the given RGBA channels stored as pixelType, the chroma channels
+// are always stored as half.
+func newRGBAOutputFile(w io.WriteSeeker, h Header, channels RGBAChannels, pixelType int32) *RGBAOutputFile {
+	h.channels = nil
+
+	if channels&WriteY != 0 {
+		h.AddChannel(Channel{Name: "Y", PixelType: pixelType, XSampling: 1, YSampling: 1})
+
+		if channels&WriteC != 0 {
+			h.AddChannel(Channel{Name: "RY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+			h.AddChannel(Channel{Name: "BY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+		}
+	} else {
+		for _, c := range []struct {
+			name string
+			bit  RGBAChannels
+		}{{"R", WriteR}, {"G", WriteG}, {"B", WriteB}} {
+			if channels&c.bit != 0 {
+				h.AddChannel(Channel{Name: c.name, PixelType: pixelType, XSampling: 1, YSampling: 1})
+			}
+		}
+	}
+
+	if channels&WriteA != 0 {
+		h.AddChannel(Channel{Name: "A", PixelType: pixelType, XSampling: 1, YSampling: 1})
+	}
+
+	return &RGBAOutputFile{
+		file:     NewOutputFile(w, h),
+		channels: channels,
+		yw:       luminanceWeights(h.Chromaticities()),
+	}
+}
+
+// SetFramebuffer sets the pixels to write, the red component of pixel (x, y) is found at
+// pixels[base+x*xStride+y*yStride] followed by green, blue and alpha.
+func (o *RGBAOutputFile) SetFramebuffer(pixels []float32, base, xStride, yStride int32) {
+	o.pixels = pixels
+	o.base, o.xStride, o.yStride = base, xStride, yStride
+	o.converted = false
+
+	if o.channels&WriteY != 0 {
+		// Pixels are converted to luminance/chroma when written
+		return
+	}
+
+	fb := Framebuffer{}
+
+	for i, name := range []string{"R", "G", "B", "A"} {
+		fb.Insert(name, Pixels{PixelTypeFloat, pixels, base + int32(i), xStride, yStride, 1, 1, 0})
+	}
+
+	o.file.SetFramebuffer(fb)
+}
+
+// convertYCA converts the whole data window of the framebuffer to luminance/chroma.  The chroma filters
+// span many scanlines so the framebuffer must hold every scanline of the image.
+func (o *RGBAOutputFile) convertYCA() error {
+	h := &o.file.header
+
+	xMin, yMin := int(h.dataWindow[0]), int(h.dataWindow[1])
+	width := int(h.dataWindow[2]-h.dataWindow[0]) + 1
+	height := int(h.dataWindow[3]-h.dataWindow[1]) + 1
+
+	if err := h.checkSampling(); err != nil {
+		return err
+	}
+
+	lum := make([]float32, width*height)
+	ry := make([]float32, width*height)
+	by := make([]float32, width*height)
+	alpha := make([]float32, width*height)
+
+	for j := 0; j < height; j++ {
+		for i := 0; i < width; i++ {
+			ofs := int(o.base) + (xMin+i)*int(o.xStride) + (yMin+j)*int(o.yStride)
+
+			if ofs < 0 || ofs+3 >= len(o.pixels) {
+				return fmt.Errorf("pixel (%v, %v) is outside framebuffer", xMin+i, yMin+j)
+			}
+
+			k := i + j*width
+			lum[k], ry[k], by[k] = rgbToYCA(o.yw, o.pixels[ofs], o.pixels[ofs+1], o.pixels[ofs+2])
+			alpha[k] = o.pixels[ofs+3]
+		}
+	}
+
+	fb := Framebuffer{}
+	fb.Insert("Y", Pixels{PixelTypeFloat, lum, -int32(xMin + yMin*width), 1, int32(width), 1, 1, 0})
+	fb.Insert("A", Pixels{PixelTypeFloat, alpha, -int32(xMin + yMin*width), 1, int32(width), 1, 1, 0})
+
+	if o.channels&WriteC != 0 {
+		w, hh := width/2, height/2
+
+		for _, c := range []struct {
+			name string
+			full []float32
+		}{{"RY", ry}, {"BY", by}} {
+			// Filter horizontally on every line then vertically
+			horiz := make([]float32, w*height)
+
+			for j := 0; j < height; j++ {
+				row := c.full[j*width : (j+1)*width]
+				decimateChroma(width, func(i int) float32 { return row[i] }, func(i int, v float32) { horiz[i+j*w] = v })
+			}
+
+			sub := make([]float32, w*hh)
+
+			for i := 0; i < w; i++ {
+				decimateChroma(height, func(j int) float32 { return horiz[i+j*w] }, func(j int, v float32) { sub[i+j*w] = v })
+			}
+
+			fb.Insert(c.name, Pixels{PixelTypeFloat, sub, -int32(xMin/2 + (yMin/2)*w), 1, int32(w), 2, 2, 0})
+		}
+	}
+
+	o.file.SetFramebuffer(fb)
+	o.converted = true
+
+	return nil
+}
+
+// WritePixels writes the next count scanlines from the framebuffer.
+func (o *RGBAOutputFile) WritePixels(count int) error {
+	return o.WritePixelsContext(context.Background(), count)
+}
+
+// WritePixelsContext is like WritePixels but stops between chunks once ctx is cancelled, returning
+// ctx.Err().
+func (o *RGBAOutputFile) WritePixelsContext(ctx context.Context, count int) error {
+	if o.channels&WriteY != 0 && !o.converted {
+		if err := o.convertYCA(); err != nil {
+			return err
+		}
+	}
+
+	return o.file.WritePixelsContext(ctx, count)
+}
+
+// RGBAInputFile reads an image into interleaved RGBA float32 pixels.  Luminance/chroma files are converted
+// to RGB, missing channels are filled with zero and missing alpha with one.
+type RGBAInputFile struct {
+	file     *InputFile
+	layer    string
+	channels RGBAChannels
+	yw       [3]float32
+
+	pixels                 []float32
+	base, xStride, yStride int32
+
+	rgba       [4][]float32     // Whole image converted from luminance/chroma
+	incomplete *IncompleteError // Scanlines missing from a luminance/chroma image
+}
+
+// NewRGBAInputFile reads the header of an image for reading as RGBA.
+func NewRGBAInputFile(r io.ReadSeeker) (*RGBAInputFile, error) {
+	return NewRGBAInputFileLayer(r, "")
+}
+
+// NewRGBAInputFileLayer reads the header of an image for reading the RGBA or luminance channels of the
+// given layer, for example "diffuse" reads "diffuse.R", "diffuse.G" etc.
+func NewRGBAInputFileLayer(r io.ReadSeeker, layer string) (*RGBAInputFile, error) {
+	file, err := NewInputFile(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newRGBAInputFile(file, layer)
+}
+
+// newRGBAInputFile reads the RGBA or luminance channels of the given layer from file.
+func newRGBAInputFile(file *InputFile, layer string) (*RGBAInputFile, error) {
+	h := &file.header
+	channels := rgbaChannels(h, layer)
+
+	if channels == 0 {
+		return nil, fmt.Errorf("layer %q contains no RGBA or luminance channels", layer)
+	}
+
+	if channels&WriteC != 0 {
+		ry, by := h.FindChannel(JoinChannelName(layer, "RY")), h.FindChannel(JoinChannelName(layer, "BY"))
+
+		if ry == nil || by == nil || ry.XSampling != by.XSampling || ry.YSampling != by.YSampling ||
+			ry.XSampling != ry.YSampling || ry.XSampling > 2 {
+			return nil, fmt.Errorf("unsupported chroma channel sampling")
+		}
+	}
+
+	return &RGBAInputFile{
+		file:     file,
+		layer:    layer,
+		channels: channels,
+		yw:       luminanceWeights(h.Chromaticities()),
+	}, nil
+}
+
+// Header returns the header of the file.
+func (f *RGBAInputFile) Header() Header {
+	return f.file.Header()
+}
+
+// Channels returns the RGBA channels present in the file.
+func (f *RGBAInputFile) Channels() RGBAChannels {
+	return f.channels
+}
+
+// SetFramebuffer sets the pixels to read into, the red component of pixel (x, y) is found at
+// pixels[base+x*xStride+y*yStride] followed by green, blue and alpha.
+func (f *RGBAInputFile) SetFramebuffer(pixels []float32, base, xStride, yStride int32) {
+	f.pixels = pixels
+	f.base, f.xStride, f.yStride = base, xStride, yStride
+
+	if f.channels&(WriteY|WriteC) != 0 {
+		return
+	}
+
+	fb := Framebuffer{}
+
+	for i, name := range []string{"R", "G", "B", "A"} {
+		p := Pixels{PixelTypeFloat, pixels, base + int32(i), xStride, yStride, 1, 1, 0}
+
+		if name == "A" {
+			p.FillValue = 1
+		}
+
+		fb.InsertLayer(f.layer, name, p)
+	}
+
+	f.file.SetFramebuffer(fb)
+}
+
+// readYCA reads the whole image and converts it from luminance/chroma to RGBA.
+func (f *RGBAInputFile) readYCA(ctx context.Context) error {
+	h := &f.file.header
+
+	xMin, yMin := int(h.dataWindow[0]), int(h.dataWindow[1])
+	width := int(h.dataWindow[2]-h.dataWindow[0]) + 1
+	height := int(h.dataWindow[3]-h.dataWindow[1]) + 1
+
+	lum := make([]float32, width*height)
+	ry := make([]float32, width*height)
+	by := make([]float32, width*height)
+	alpha := make([]float32, width*height)
+
+	fb := Framebuffer{}
+	fb.InsertLayer(f.layer, "Y", Pixels{PixelTypeFloat, lum, -int32(xMin + yMin*width), 1, int32(width), 1, 1, 0})
+	fb.InsertLayer(f.layer, "A", Pixels{PixelTypeFloat, alpha, -int32(xMin + yMin*width), 1, int32(width), 1, 1, 1})
+
+	var subRY, subBY []float32
+
+	s := 1
+
+	if f.channels&WriteC != 0 {
+		s = int(h.FindChannel(JoinChannelName(f.layer, "RY")).XSampling)
+
+		if s == 1 {
+			subRY, subBY = ry, by
+		} else {
+			subRY = make([]float32, (width/2)*(height/2))
+			subBY = make([]float32, (width/2)*(height/2))
+		}
+
+		w := width / s
+		base := -int32(divp(xMin, s) + divp(yMin, s)*w)
+
+		fb.InsertLayer(f.layer, "RY", Pixels{PixelTypeFloat, subRY, base, 1, int32(w), s, s, 0})
+		fb.InsertLayer(f.layer, "BY", Pixels{PixelTypeFloat, subBY, base, 1, int32(w), s, s, 0})
+	}
+
+	f.file.SetFramebuffer(fb)
+
+	if err := f.file.ReadPixelsContext(ctx, yMin, yMin+height-1); err != nil {
+		incomplete, ok := err.(*IncompleteError)
+
+		if !ok {
+			return err
+		}
+
+		// Convert the scanlines which could be read
+		f.incomplete = incomplete
+	}
+
+	if s == 2 {
+		w, hh := width/2, height/2
+
+		for _, c := range []struct {
+			sub, full []float32
+		}{{subRY, ry}, {subBY, by}} {
+			// Reconstruct horizontally on the sampled lines then vertically
+			horiz := make([]float32, width*hh)
+
+			for j := 0; j < hh; j++ {
+				sub := c.sub
+				reconstructChroma(width, func(i int) float32 { return sub[i+j*w] }, func(i int, v float32) { horiz[i+j*width] = v })
+			}
+
+			full := c.full
+
+			for i := 0; i < width; i++ {
+				reconstructChroma(height, func(j int) float32 { return horiz[i+j*width] }, func(j int, v float32) { full[i+j*width] = v })
+			}
+		}
+	}
+
+	r := make([]float32, width*height)
+	g := make([]float32, width*height)
+	b := make([]float32, width*height)
+
+	for k := range lum {
+		r[k], g[k], b[k] = ycaToRGB(f.yw, lum[k], ry[k], by[k])
+	}
+
+	f.rgba = [4][]float32{r, g, b, alpha}
+
+	return nil
+}
+
+// ReadPixels reads the scanlines between y1 and y2 (inclusive) into the framebuffer.
+func (f *RGBAInputFile) ReadPixels(y1, y2 int) error {
+	return f.ReadPixelsContext(context.Background(), y1, y2)
+}
+
+// ReadPixelsContext is like ReadPixels but stops between chunks once ctx is cancelled, returning ctx.Err().
+func (f *RGBAInputFile) ReadPixelsContext(ctx context.Context, y1, y2 int) error {
+	if f.channels&(WriteY|WriteC) == 0 {
+		return f.file.ReadPixelsContext(ctx, y1, y2)
+	}
+
+	h := &f.file.header
+
+	xMin, yMin := int(h.dataWindow[0]), int(h.dataWindow[1])
+	xMax, yMax := int(h.dataWindow[2]), int(h.dataWindow[3])
+	width := xMax - xMin + 1
+
+	if y1 > y2 || y1 < yMin || y2 > yMax {
+		return fmt.Errorf("scanlines %v to %v are outside data window", y1, y2)
+	}
+
+	if f.rgba[0] == nil {
+		if err := f.readYCA(ctx); err != nil {
+			return err
+		}
+	}
+
+	for y := y1; y <= y2; y++ {
+		for x := xMin; x <= xMax; x++ {
+			ofs := int(f.base) + x*int(f.xStride) + y*int(f.yStride)
+
+			if ofs < 0 || ofs+3 >= len(f.pixels) {
+				return fmt.Errorf("pixel (%v, %v) is outside framebuffer", x, y)
+			}
+
+			k := (x - xMin) + (y-yMin)*width
+
+			for c := range f.rgba {
+				f.pixels[ofs+c] = f.rgba[c][k]
+			}
+		}
+	}
+
+	if f.incomplete != nil {
+		var missing []int
+
+		for _, y := range f.incomplete.Missing {
+			if y >= y1 && y <= y2 {
+				missing = append(missing, y)
+			}
+		}
+
+		if len(missing) > 0 {
+			return &IncompleteError{Missing: missing, Errs: f.incomplete.Errs}
+		}
+	}
+
+	return nil
+}
+
+// LoadRGBA reads an image and returns the size of its data window and the pixels as interleaved RGBA.
+// Missing channels are filled with zero, missing alpha with one and luminance images are expanded to RGB.
+// If scanlines are missing from the file then the pixels are returned along with an *IncompleteError.
+func LoadRGBA(r io.Reader) (width, height int, data []float32, err error) {
+	rs, err := readSeeker(r)
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	in, err := NewRGBAInputFile(rs)
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	h := in.Header()
+	xMin, yMin, xMax, yMax := h.DataWindow()
+
+	width, height = int(xMax-xMin+1), int(yMax-yMin+1)
+	data = make([]float32, width*height*4)
+
+	in.SetFramebuffer(data, -(xMin*4 + yMin*int32(width)*4), 4, int32(width)*4)
+
+	if err := in.ReadPixels(int(yMin), int(yMax)); err != nil {
+		if _, ok := err.(*IncompleteError); ok {
+			return width, height, data, err
+		}
+
+		return 0, 0, nil, err
+	}
+
+	return width, height, data, nil
+}
+
+// SaveOptions controls how SaveRGBA writes an image.
+type SaveOptions struct {
+	PixelType   int32        // PixelTypeHalf or PixelTypeFloat, if zero then PixelTypeHalf
+	Compression int          // One of CompressionTypeNone...
+	Channels    RGBAChannels // Channels to write, if zero RGBA is written and alpha is omitted when every pixel is opaque
+
+	Chromaticities *Chromaticities // Primaries of the pixels, written as the chromaticities attribute if not nil
+}
+
+// SaveRGBA writes width*height pixels of interleaved RGBA as an image.  If opts is nil then half pixels
+// with ZIP compression are written.
+func SaveRGBA(w io.Writer, width, height int, data []float32, opts *SaveOptions) error {
+	if opts == nil {
+		opts = &SaveOptions{PixelType: PixelTypeHalf, Compression: CompressionTypeZip}
+	}
+
+	if width < 1 || height < 1 || len(data) < width*height*4 {
+		return fmt.Errorf("invalid image size %vx%v for %v values", width, height, len(data))
+	}
+
+	channels := opts.Channels
+
+	if channels == 0 {
+		channels = WriteRGB
+
+		if !opaque(data[:width*height*4]) {
+			channels = WriteRGBA
+		}
+	}
+
+	h := NewHeader(width, height)
+	h.SetCompression(opts.Compression)
+
+	if opts.Chromaticities != nil {
+		h.SetChromaticities(*opts.Chromaticities)
+	}
+
+	pixelType := opts.PixelType
+
+	if pixelType == PixelTypeUInt {
+		pixelType = PixelTypeHalf
+	}
+
+	return writeRGBA(w, h, data, pixelType, channels)
+}
+
+// opaque returns true if every alpha value in the interleaved RGBA data is 1.
+func opaque(data []float32) bool {
+	for i := 3; i < len(data); i += 4 {
+		if data[i] != 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeRGBA writes interleaved RGBA data covering the data window of h, starting at data[0].  If w can't
+// seek then the file is assembled in memory.
+func writeRGBA(w io.Writer, h Header, data []float32, pixelType int32, channels RGBAChannels) error {
+	if pixelType != PixelTypeHalf && pixelType != PixelTypeFloat {
+		return fmt.Errorf("invalid pixel type (%v) for RGBA image", pixelType)
+	}
+
+	ws, ok := w.(io.WriteSeeker)
+
+	var buf *writeSeekBuffer
+
+	if !ok {
+		buf = &writeSeekBuffer{}
+		ws = buf
+	}
+
+	xMin, yMin, xMax, yMax := h.DataWindow()
+	width := xMax - xMin + 1
+
+	of := newRGBAOutputFile(ws, h, channels, pixelType)
+	of.SetFramebuffer(data, -(xMin*4 + yMin*width*4), 4, width*4)
+
+	if err := of.WritePixels(int(yMax - yMin + 1)); err != nil {
+		return err
+	}
+
+	if buf != nil {
+		if _, err := w.Write(buf.buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package exr
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"testing"
+)
+
+func genRGBA(width, height int) []float32 {
+	pixels := make([]float32, width*height*4)
+
+	for y := 0; y < height; y++ {
+		ydeg := float64(y) / float64(height)
+		for x := 0; x < width; x++ {
+			xdeg := float64(x) / float64(width)
+
+			k := (x + y*width) * 4
+			pixels[k] = float32(0.1 + math.Abs(math.Sin(xdeg*2)*math.Cos(ydeg*4)))
+			pixels[k+1] = float32(0.1 + math.Abs(math.Sin(xdeg)*math.Sin(ydeg*2)))
+			pixels[k+2] = float32(0.1 + math.Abs(math.Sin(xdeg*3)*math.Cos(ydeg*5)))
+			pixels[k+3] = float32(xdeg)
+		}
+	}
+
+	return pixels
+}
+
+func TestRGBARoundTrip(t *testing.T) {
+	testCases := []struct {
+		name      string
+		channels  RGBAChannels
+		tolerance float64
+	}{
+		{"rgba", WriteRGBA, 1e-3},
+		{"yca", WriteYCA, 5e-2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pixels := genRGBA(128, 96)
+
+			f, err := os.Create("testdata/out_" + tc.name + ".exr")
+			defer f.Close()
+
+			if err != nil {
+				t.Fatalf("error creating testdata: %v", err)
+			}
+
+			hd := NewHeader(128, 96)
+			hd.SetCompression(CompressionTypeZip)
+
+			of := NewRGBAOutputFile(f, hd, tc.channels)
+			of.SetFramebuffer(pixels, 0, 4, 128*4)
+
+			if err := of.WritePixels(96); err != nil {
+				t.Fatalf("error writing scanlines: %v", err)
+			}
+
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatalf("error seeking testdata: %v", err)
+			}
+
+			in, err := NewRGBAInputFile(f)
+
+			if err != nil {
+				t.Fatalf("error reading testdata: %v", err)
+			}
+
+			if in.Channels() != tc.channels {
+				t.Fatalf("expected channels %x, got %x", tc.channels, in.Channels())
+			}
+
+			out := make([]float32, len(pixels))
+			in.SetFramebuffer(out, 0, 4, 128*4)
+
+			if err := in.ReadPixels(0, 95); err != nil {
+				t.Fatalf("error reading scanlines: %v", err)
+			}
+
+			for i := range pixels {
+				if math.Abs(float64(pixels[i]-out[i])) > tc.tolerance {
+					t.Fatalf("pixel %v component %v: expected %v, got %v", i/4, i%4, pixels[i], out[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLuminanceWeights(t *testing.T) {
+	yw := luminanceWeights(Rec709Chromaticities)
+	expected := [3]float32{0.2126, 0.7152, 0.0722}
+
+	for i := range yw {
+		if math.Abs(float64(yw[i]-expected[i])) > 1e-4 {
+			t.Fatalf("expected weights %v, got %v", expected, yw)
+		}
+	}
+}
+
+func TestLoadSaveRGBA(t *testing.T) {
+	pixels := genRGBA(64, 32)
+
+	buf := &bytes.Buffer{}
+
+	if err := SaveRGBA(buf, 64, 32, pixels, &SaveOptions{PixelType: PixelTypeFloat, Compression: CompressionTypeRLE}); err != nil {
+		t.Fatalf("error saving image: %v", err)
+	}
+
+	width, height, data, err := LoadRGBA(buf)
+
+	if err != nil {
+		t.Fatalf("error loading image: %v", err)
+	}
+
+	if width != 64 || height != 32 {
+		t.Fatalf("expected 64x32 image, got %vx%v", width, height)
+	}
+
+	for i := range pixels {
+		if pixels[i] != data[i] {
+			t.Fatalf("pixel %v component %v: expected %v, got %v", i/4, i%4, pixels[i], data[i])
+		}
+	}
+
+	// A zero pixel type is half
+	buf.Reset()
+
+	if err := SaveRGBA(buf, 64, 32, pixels, &SaveOptions{Compression: CompressionTypeZip}); err != nil {
+		t.Fatalf("error saving image with zero pixel type: %v", err)
+	}
+
+	in, err := NewRGBAInputFile(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+
+	if h := in.Header(); h.FindChannel("R").PixelType != PixelTypeHalf {
+		t.Fatalf("expected half pixels, got %v", h.FindChannel("R").PixelType)
+	}
+}
+
+func TestLoadRGBA(t *testing.T) {
+	f, err := os.Open("testdata/asakusa.exr")
+
+	if err != nil {
+		t.Fatalf("error loading testdata: %v", err)
+	}
+
+	defer f.Close()
+
+	width, height, data, err := LoadRGBA(f)
+
+	if err != nil {
+		t.Fatalf("error loading image: %v", err)
+	}
+
+	if width != 660 || height != 440 || len(data) != width*height*4 {
+		t.Fatalf("expected 660x440 image, got %vx%v (%v values)", width, height, len(data))
+	}
+}
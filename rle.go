@@ -2,15 +2,32 @@ package exr
 
 import (
 	"bytes"
+	"fmt"
 )
 
 // rleEncode will apply an EXR specific preprocess and then byte-level RLE compress the buffer.
 // It will return either the compressed buffer or the original buffer depending on which is smaller.
 func rleEncode(buf []byte) []byte {
+	tmpBuf := predictorEncode(buf)
+
+	// Now perform rle encode on tmpBuf
+	compressed := rleCompress(tmpBuf)
+
+	if len(compressed) < len(buf) {
+		return compressed
+	}
+
+	return buf
+}
+
+// predictorEncode applies the EXR specific preprocess shared by the RLE and ZIP compressors.  From OpenEXR's
+// ImfRleCompressor.cpp and tinyexr.
+func predictorEncode(buf []byte) []byte {
 	tmpBuf := make([]byte, len(buf))
 
-	// Apply EXR-specific preprocess.  From OpenEXR's ImfRleCompressor.cpp
-	// And tinyexr
+	if len(buf) == 0 {
+		return tmpBuf
+	}
 
 	// 1) Reorder the pixel data
 	t1 := 0
@@ -44,14 +61,8 @@ func rleEncode(buf []byte) []byte {
 		p = tmpBuf[t]
 		tmpBuf[t] = byte(d)
 	}
-	// Now perform rle encode on tmpBuf
-	compressed := rleCompress(tmpBuf)
 
-	if len(compressed) < len(buf) {
-		return compressed
-	}
-
-	return buf
+	return tmpBuf
 }
 
 const (
@@ -97,9 +108,20 @@ func rleCompress(in []byte) []byte {
 	return out.Bytes()
 }
 
-func rleDecode(buf []byte) []byte {
-	tmpBuf := rleDecompress(buf)
+// rleDecode decompresses buf, which must expand to at most maxSize bytes, and reverses the EXR specific
+// preprocess.
+func rleDecode(buf []byte, maxSize int) ([]byte, error) {
+	out, err := rleDecompress(buf, maxSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return predictorDecode(out), nil
+}
 
+// predictorDecode reverses predictorEncode, tmpBuf is modified in place.
+func predictorDecode(tmpBuf []byte) []byte {
 	// Predictor
 	t := 1
 	stop := len(tmpBuf)
@@ -135,33 +157,52 @@ func rleDecode(buf []byte) []byte {
 	return out.Bytes()
 }
 
-func rleDecompress(buf []byte) []byte {
+// rleDecompress expands byte-level RLE compressed data, corrupt data or output larger than maxSize bytes
+// is an error.
+func rleDecompress(buf []byte, maxSize int) ([]byte, error) {
 	out := bytes.Buffer{}
 
 	in := 0
 
 	for in < len(buf) {
 
-		count := int8(buf[in])
+		count := int(int8(buf[in]))
 		in++
 
 		if count < 0 {
-			count := -count
-			out.Write(buf[in : in+int(count)])
+			count = -count
+
+			if in+count > len(buf) {
+				return nil, fmt.Errorf("rle: literal run of %v bytes at %v overruns data of %v bytes", count, in, len(buf))
+			}
 
-			in += int(count)
+			if out.Len()+count > maxSize {
+				return nil, fmt.Errorf("rle: decompressed data is larger than %v bytes", maxSize)
+			}
+
+			out.Write(buf[in : in+count])
+
+			in += count
 		} else {
+			if in >= len(buf) {
+				return nil, fmt.Errorf("rle: missing value for run at %v", in)
+			}
+
+			if out.Len()+count+1 > maxSize {
+				return nil, fmt.Errorf("rle: decompressed data is larger than %v bytes", maxSize)
+			}
+
 			val := buf[in]
 			in++
 
-			for i := 0; i < int(count)+1; i++ {
+			for i := 0; i < count+1; i++ {
 				out.WriteByte(val)
 			}
 
 		}
 	}
 
-	return out.Bytes()
+	return out.Bytes(), nil
 }
 
 /*
@@ -27,7 +27,11 @@ func TestRLECompressDecompress(t *testing.T) {
 				t.Logf("didn't compress, len(out) == len(tc)")
 			} else {
 
-				decomp := rleDecompress(out)
+				decomp, err := rleDecompress(out, len(tc))
+
+				if err != nil {
+					t.Fatalf("error decompressing: %v", err)
+				}
 				//t.Logf("decomp: %v %v", decomp, string(decomp))
 
 				if bytes.Compare(tc, decomp) != 0 {
@@ -57,7 +61,11 @@ func TestRLEEncodeDecode(t *testing.T) {
 			if len(out) == len(tc) {
 				t.Logf("didn't compress, len(out) == len(tc)")
 			} else {
-				decomp := rleDecode(out)
+				decomp, err := rleDecode(out, len(tc))
+
+				if err != nil {
+					t.Fatalf("error decoding: %v", err)
+				}
 
 				//t.Logf("decomp: %v %v", decomp, string(decomp))
 
@@ -71,3 +79,21 @@ func TestRLEEncodeDecode(t *testing.T) {
 	}
 
 }
+
+func FuzzRLEDecode(f *testing.F) {
+	f.Add(rleEncode([]byte("1011011011011012022022022222222222222220000001011011013233233240000000003233232323322323323323323323323")), 128)
+	f.Add([]byte{0x80}, 16)
+	f.Add([]byte{0x7f}, 16)
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize int) {
+		if maxSize < 0 || maxSize > 1<<16 {
+			return
+		}
+
+		out, err := rleDecode(data, maxSize)
+
+		if err == nil && len(out) > maxSize {
+			t.Fatalf("decoded %v bytes, limit was %v", len(out), maxSize)
+		}
+	})
+}
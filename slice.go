@@ -0,0 +1,311 @@
+package exr
+
+import (
+	"fmt"
+)
+
+// Sample is the set of types which can hold the pixel data of a channel in memory.
+type Sample interface {
+	Half | float32 | uint32
+}
+
+// Slice describes where the samples of a single channel are stored.  The sample for pixel (x, y) is
+// found at Data[Base+(x/XSampling)*XStride+(y/YSampling)*YStride].
+type Slice[T Sample] struct {
+	Data                 []T
+	Base                 int
+	XStride, YStride     int
+	XSampling, YSampling int     // only for sub-sampled images
+	FillValue            float64 // Value for channels missing from a file when reading
+}
+
+// NewPlanarSlice returns a slice for a channel stored alone in data, in rows of width samples starting
+// with pixel (xMin, yMin).
+func NewPlanarSlice[T Sample](data []T, xMin, yMin, width int) Slice[T] {
+	return Slice[T]{
+		Data:      data,
+		Base:      -(xMin + yMin*width),
+		XStride:   1,
+		YStride:   width,
+		XSampling: 1,
+		YSampling: 1,
+	}
+}
+
+// NewInterleavedSlice returns a slice for one of numChannels channels stored pixel by pixel in data, in
+// rows of width pixels starting with pixel (xMin, yMin).  For example channel 1 of RGBA data is green.
+func NewInterleavedSlice[T Sample](data []T, xMin, yMin, width, numChannels, channel int) Slice[T] {
+	return Slice[T]{
+		Data:      data,
+		Base:      channel - (xMin+yMin*width)*numChannels,
+		XStride:   numChannels,
+		YStride:   width * numChannels,
+		XSampling: 1,
+		YSampling: 1,
+	}
+}
+
+// pixelTypeOf returns the pixel type with the same representation as T.
+func pixelTypeOf[T Sample]() int {
+	var v T
+
+	switch any(v).(type) {
+	case Half:
+		return PixelTypeHalf
+	case uint32:
+		return PixelTypeUInt
+	}
+
+	return PixelTypeFloat
+}
+
+// Pixels returns the slice as an untyped Pixels.
+func (s Slice[T]) Pixels() Pixels {
+	return Pixels{
+		Kind:      pixelTypeOf[T](),
+		Data:      s.Data,
+		Base:      int32(s.Base),
+		XStride:   int32(s.XStride),
+		YStride:   int32(s.YStride),
+		XSampling: s.XSampling,
+		YSampling: s.YSampling,
+		FillValue: s.FillValue,
+	}
+}
+
+// InsertSlice inserts a typed slice of pixel data for the given channel.
+func InsertSlice[T Sample](fb *Framebuffer, ch string, s Slice[T]) {
+	fb.Insert(ch, s.Pixels())
+}
+
+// appendSample appends v to buf converted to pixelType.
+func appendSample[T Sample](buf []byte, pixelType int32, v T) []byte {
+	switch t := any(v).(type) {
+	case Half:
+		return appendHalf(buf, pixelType, t)
+	case uint32:
+		return appendUint(buf, pixelType, t)
+	case float32:
+		return appendFloat32(buf, pixelType, t)
+	}
+
+	return buf
+}
+
+// convertSample returns the first sample in data, which is of pixelType, as a T.
+func convertSample[T Sample](data []byte, pixelType int32) T {
+	var v T
+
+	switch t := any(&v).(type) {
+	case *Half:
+		*t = sampleHalf(data, pixelType)
+	case *uint32:
+		*t = sampleUint(data, pixelType)
+	case *float32:
+		*t = sampleFloat32(data, pixelType)
+	}
+
+	return v
+}
+
+// fromFloat64 converts v to a T.
+func fromFloat64[T Sample](v float64) T {
+	var s T
+
+	switch t := any(&s).(type) {
+	case *Half:
+		*t = Half(Float32ToFloat16(float32(v)))
+	case *uint32:
+		*t = floatToUint(float32(v))
+	case *float32:
+		*t = float32(v)
+	}
+
+	return s
+}
+
+// encodeSamples appends the samples of scanline y between xMin and xMax from data, addressed by p, to buf.
+func encodeSamples[T Sample](buf []byte, data []T, p *Pixels, pixelType int32, y, xMin, xMax int) ([]byte, error) {
+	xs, _ := p.sampling()
+
+	for x := xMin + mod(-xMin, xs); x <= xMax; x += xs {
+		ofs := p.offset(x, y)
+
+		if ofs < 0 || ofs >= len(data) {
+			return nil, fmt.Errorf("pixel (%v, %v) is outside framebuffer slice", x, y)
+		}
+
+		buf = appendSample(buf, pixelType, data[ofs])
+	}
+
+	return buf, nil
+}
+
+// decodeSamples stores the samples of scanline y between xMin and xMax from src into data, addressed by p.
+// It returns the remaining source data.
+func decodeSamples[T Sample](src []byte, data []T, p *Pixels, pixelType int32, y, xMin, xMax int) ([]byte, error) {
+	xs, _ := p.sampling()
+	size := pixelTypeSize(pixelType)
+
+	for x := xMin + mod(-xMin, xs); x <= xMax; x += xs {
+		ofs := p.offset(x, y)
+
+		if len(src) < size {
+			return nil, fmt.Errorf("not enough pixel data for scanline %v", y)
+		}
+
+		if ofs < 0 || ofs >= len(data) {
+			return nil, fmt.Errorf("pixel (%v, %v) is outside framebuffer slice", x, y)
+		}
+
+		data[ofs] = convertSample[T](src, pixelType)
+		src = src[size:]
+	}
+
+	return src, nil
+}
+
+// fillSamples sets the samples of scanline y between xMin and xMax in data, addressed by p, to p.FillValue.
+func fillSamples[T Sample](data []T, p *Pixels, y, xMin, xMax int) error {
+	xs, ys := p.sampling()
+
+	if mod(y, ys) != 0 {
+		return nil
+	}
+
+	v := fromFloat64[T](p.FillValue)
+
+	for x := xMin + mod(-xMin, xs); x <= xMax; x += xs {
+		ofs := p.offset(x, y)
+
+		if ofs < 0 || ofs >= len(data) {
+			return fmt.Errorf("pixel (%v, %v) is outside framebuffer slice", x, y)
+		}
+
+		data[ofs] = v
+	}
+
+	return nil
+}
+
+// At returns the sample for pixel (x, y), the pixel must be within the slice.
+func (s Slice[T]) At(x, y int) T {
+	return s.Data[s.offset(x, y)]
+}
+
+// Set sets the sample for pixel (x, y), the pixel must be within the slice.
+func (s Slice[T]) Set(x, y int, v T) {
+	s.Data[s.offset(x, y)] = v
+}
+
+func (s Slice[T]) offset(x, y int) int {
+	xs, ys := s.XSampling, s.YSampling
+
+	if xs == 0 {
+		xs = 1
+	}
+
+	if ys == 0 {
+		ys = 1
+	}
+
+	return s.Base + divp(x, xs)*s.XStride + divp(y, ys)*s.YStride
+}
+
+// Layout selects how AllocateFramebuffer stores channels in memory.
+type Layout int
+
+const (
+	LayoutPlanar      Layout = iota // Each channel in its own slice
+	LayoutInterleaved               // Channels with the same sampling share a slice, stored pixel by pixel
+)
+
+// AllocatedFramebuffer is a Framebuffer with storage allocated by AllocateFramebuffer.
+type AllocatedFramebuffer[T Sample] struct {
+	Framebuffer
+
+	slices map[string]Slice[T]
+}
+
+// AllocateFramebuffer allocates storage of type T for the data window of the header and inserts a slice for
+// each of the named channels, or every channel in the header if none are given.  Sub-sampled channels only
+// have storage for their samples.
+func AllocateFramebuffer[T Sample](h *Header, layout Layout, channels ...string) (*AllocatedFramebuffer[T], error) {
+	if err := h.checkSampling(); err != nil {
+		return nil, err
+	}
+
+	if len(channels) == 0 {
+		for _, ch := range h.channels {
+			channels = append(channels, ch.Name)
+		}
+	}
+
+	// Group the channels that will share storage
+	type group struct {
+		xs, ys int
+		names  []string
+	}
+
+	var groups []*group
+
+	for _, name := range channels {
+		ch := h.FindChannel(name)
+
+		if ch == nil {
+			return nil, fmt.Errorf("channel %v is not in header", name)
+		}
+
+		xs, ys := int(ch.XSampling), int(ch.YSampling)
+
+		var g *group
+
+		if layout == LayoutInterleaved {
+			for _, other := range groups {
+				if other.xs == xs && other.ys == ys {
+					g = other
+				}
+			}
+		}
+
+		if g == nil {
+			g = &group{xs: xs, ys: ys}
+			groups = append(groups, g)
+		}
+
+		g.names = append(g.names, name)
+	}
+
+	xMin, yMin := int(h.dataWindow[0]), int(h.dataWindow[1])
+	width := int(h.dataWindow[2]-h.dataWindow[0]) + 1
+	height := int(h.dataWindow[3]-h.dataWindow[1]) + 1
+
+	fb := &AllocatedFramebuffer[T]{slices: map[string]Slice[T]{}}
+
+	for _, g := range groups {
+		w, n := width/g.xs, len(g.names)
+		data := make([]T, w*(height/g.ys)*n)
+
+		for c, name := range g.names {
+			s := Slice[T]{
+				Data:      data,
+				Base:      c - (divp(xMin, g.xs)+divp(yMin, g.ys)*w)*n,
+				XStride:   n,
+				YStride:   w * n,
+				XSampling: g.xs,
+				YSampling: g.ys,
+			}
+
+			fb.slices[name] = s
+			InsertSlice(&fb.Framebuffer, name, s)
+		}
+	}
+
+	return fb, nil
+}
+
+// Channel returns the slice for the named channel.
+func (fb *AllocatedFramebuffer[T]) Channel(name string) (Slice[T], bool) {
+	s, ok := fb.slices[name]
+	return s, ok
+}
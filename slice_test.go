@@ -0,0 +1,153 @@
+package exr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSlice(t *testing.T) {
+	const width, height = 16, 8
+
+	rgb := make([]float32, width*height*3)
+
+	for i := range rgb {
+		rgb[i] = float32(i%97) / 4
+	}
+
+	ids := make([]uint32, width*height)
+
+	for i := range ids {
+		ids[i] = uint32(i * 3)
+	}
+
+	h := NewHeaderWindow(4, -2, 4+width-1, -2+height-1)
+	h.SetCompression(CompressionTypeRLE)
+
+	for _, name := range []string{"R", "G", "B"} {
+		h.AddChannel(Channel{Name: name, PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+	}
+
+	h.AddChannel(Channel{Name: "id", PixelType: PixelTypeUInt, XSampling: 1, YSampling: 1})
+
+	fb := Framebuffer{}
+	InsertSlice(&fb, "R", NewInterleavedSlice(rgb, 4, -2, width, 3, 0))
+	InsertSlice(&fb, "G", NewInterleavedSlice(rgb, 4, -2, width, 3, 1))
+	InsertSlice(&fb, "B", NewInterleavedSlice(rgb, 4, -2, width, 3, 2))
+	InsertSlice(&fb, "id", NewPlanarSlice(ids, 4, -2, width))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	g := make([]Half, width*height)
+	idsIn := make([]uint32, width*height)
+	z := NewPlanarSlice(make([]float32, width*height), 4, -2, width)
+	z.FillValue = 2
+
+	fb = Framebuffer{}
+	InsertSlice(&fb, "G", NewPlanarSlice(g, 4, -2, width))
+	InsertSlice(&fb, "id", NewPlanarSlice(idsIn, 4, -2, width))
+	InsertSlice(&fb, "Z", z)
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixels(-2, -2+height-1); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	for i := range g {
+		if expected := Half(Float32ToFloat16(rgb[i*3+1])); g[i] != expected {
+			t.Fatalf("G pixel %v: expected %v, got %v", i, expected, g[i])
+		}
+
+		if idsIn[i] != ids[i] {
+			t.Fatalf("id pixel %v: expected %v, got %v", i, ids[i], idsIn[i])
+		}
+
+		if z.Data[i] != 2 {
+			t.Fatalf("Z pixel %v: expected fill value 2, got %v", i, z.Data[i])
+		}
+	}
+}
+
+func TestAllocateFramebuffer(t *testing.T) {
+	h := NewHeaderWindow(-4, 2, 11, 9)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	h.AddChannel(Channel{Name: "A", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	h.AddChannel(Channel{Name: "RY", PixelType: PixelTypeFloat, XSampling: 2, YSampling: 2})
+	h.AddChannel(Channel{Name: "BY", PixelType: PixelTypeFloat, XSampling: 2, YSampling: 2})
+
+	for _, layout := range []Layout{LayoutPlanar, LayoutInterleaved} {
+		out, err := AllocateFramebuffer[float32](&h, layout)
+
+		if err != nil {
+			t.Fatalf("error allocating framebuffer: %v", err)
+		}
+
+		for _, name := range []string{"Y", "A", "RY", "BY"} {
+			s, ok := out.Channel(name)
+
+			if !ok {
+				t.Fatalf("missing channel %v", name)
+			}
+
+			for y := 2; y <= 9; y += s.YSampling {
+				for x := -4; x <= 11; x += s.XSampling {
+					s.Set(x, y, float32(x*100+y+len(name)))
+				}
+			}
+		}
+
+		ws := &writeSeekBuffer{}
+		of := NewOutputFile(ws, h)
+		of.SetFramebuffer(out.Framebuffer)
+
+		if err := of.WritePixels(8); err != nil {
+			t.Fatalf("error writing scanlines: %v", err)
+		}
+
+		in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+		if err != nil {
+			t.Fatalf("error reading file: %v", err)
+		}
+
+		hIn := in.Header()
+		fb, err := AllocateFramebuffer[float32](&hIn, layout, "RY", "A")
+
+		if err != nil {
+			t.Fatalf("error allocating framebuffer: %v", err)
+		}
+
+		if _, ok := fb.Channel("Y"); ok {
+			t.Fatalf("unexpected channel Y")
+		}
+
+		in.SetFramebuffer(fb.Framebuffer)
+
+		if err := in.ReadPixels(2, 9); err != nil {
+			t.Fatalf("error reading scanlines: %v", err)
+		}
+
+		for _, name := range []string{"RY", "A"} {
+			s, _ := fb.Channel(name)
+
+			for y := 2; y <= 9; y += s.YSampling {
+				for x := -4; x <= 11; x += s.XSampling {
+					if v := s.At(x, y); v != float32(x*100+y+len(name)) {
+						t.Fatalf("%v pixel (%v, %v): expected %v, got %v", name, x, y, x*100+y+len(name), v)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package exr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// defaultMaxSpoolMemory is the size of the file a StreamOutputFile keeps in memory by default.
+const defaultMaxSpoolMemory = 64 << 20
+
+// StreamOutputFile writes an image to an io.Writer which can't seek, such as a pipe, an HTTP response or
+// a tar stream.  As the offset table precedes the pixel data the file is spooled, in memory up to
+// WriteOptions.MaxSpoolMemory bytes and then in a temporary file, and copied to the writer by Close.
+type StreamOutputFile struct {
+	*OutputFile
+
+	w     io.Writer
+	spool *spool
+}
+
+// NewStreamOutputFile returns a StreamOutputFile writing to w with the given options, nil gives the
+// defaults.
+func NewStreamOutputFile(w io.Writer, h Header, opts *WriteOptions) *StreamOutputFile {
+	s := &spool{limit: defaultMaxSpoolMemory}
+
+	if opts != nil && opts.MaxSpoolMemory > 0 {
+		s.limit = opts.MaxSpoolMemory
+	}
+
+	return &StreamOutputFile{
+		OutputFile: NewOutputFileOptions(s, h, opts),
+		w:          w,
+		spool:      s,
+	}
+}
+
+// Close writes the file to the writer once every scanline has been written and removes any temporary
+// file.  It must be called once writing is finished, the writer is not closed.
+func (o *StreamOutputFile) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	defer o.spool.close()
+
+	if !o.headerWritten || o.chunksWritten != o.numChunks {
+		return fmt.Errorf("closing file with %v of %v chunks written", o.chunksWritten, o.numChunks)
+	}
+
+	return o.spool.writeTo(o.w)
+}
+
+// spool is an io.WriteSeeker kept in memory until it grows beyond limit bytes, then in a temporary file.
+type spool struct {
+	limit int64
+	mem   writeSeekBuffer
+	file  *os.File
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && int64(s.mem.pos+len(p)) > s.limit {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+
+	return s.mem.Write(p)
+}
+
+func (s *spool) Seek(offset int64, whence int) (int64, error) {
+	if s.file != nil {
+		return s.file.Seek(offset, whence)
+	}
+
+	return s.mem.Seek(offset, whence)
+}
+
+// spill moves the data written so far to a temporary file.
+func (s *spool) spill() error {
+	f, err := os.CreateTemp("", "exr-spool-*")
+
+	if err != nil {
+		return fmt.Errorf("creating spool file: %v", err)
+	}
+
+	if _, err := f.Write(s.mem.buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return fmt.Errorf("writing spool file: %v", err)
+	}
+
+	if _, err := f.Seek(int64(s.mem.pos), io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return fmt.Errorf("seeking in spool file: %v", err)
+	}
+
+	s.file = f
+	s.mem = writeSeekBuffer{}
+
+	return nil
+}
+
+// writeTo copies the spooled data to w.
+func (s *spool) writeTo(w io.Writer) error {
+	if s.file == nil {
+		if _, err := w.Write(s.mem.buf); err != nil {
+			return fmt.Errorf("writing file: %v", err)
+		}
+
+		return nil
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking in spool file: %v", err)
+	}
+
+	if _, err := io.Copy(w, s.file); err != nil {
+		return fmt.Errorf("writing file: %v", err)
+	}
+
+	return nil
+}
+
+// close releases the spooled data.
+func (s *spool) close() {
+	s.mem = writeSeekBuffer{}
+
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
+
+// StreamInputFile reads a scanline image from an io.Reader which can't seek, such as a pipe or an HTTP
+// body.  The line order must be increasing y, the chunks are read in the order they're stored without
+// using the offset table, so only one chunk is held in memory at a time.
+type StreamInputFile struct {
+	file InputFile
+	r    *bufio.Reader
+	next int // Next chunk to read
+}
+
+// NewStreamInputFile reads the version, header and offset table from r.  The options apply as for
+// NewInputFileOptions except that chunks are decoded on the calling goroutine, if opts is nil then
+// DefaultLimits apply.
+func NewStreamInputFile(r io.Reader, opts *ReadOptions) (*StreamInputFile, error) {
+	limits := opts.limits()
+
+	br := bufio.NewReader(r)
+
+	version, header, err := readFileHeader(br, limits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if header.tiled {
+		return nil, fmt.Errorf("tiled images can't be streamed")
+	}
+
+	if header.lineOrder != LineOrderIncreasingY {
+		return nil, fmt.Errorf("images with line order %v can't be streamed", header.lineOrder)
+	}
+
+	offsetTable, err := readOffsetTable(br, &header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamInputFile{
+		file: InputFile{
+			header:      header,
+			version:     version,
+			offsetTable: offsetTable,
+			counted:     make([]int32, len(offsetTable)),
+			limits:      limits,
+			progress:    opts.progressFunc(),
+		},
+		r: br,
+	}, nil
+}
+
+// Header returns the header of the file.
+func (f *StreamInputFile) Header() Header {
+	return f.file.header
+}
+
+// SetFramebuffer sets the framebuffer the scanlines are read into.  It may be changed between chunks.
+func (f *StreamInputFile) SetFramebuffer(fb Framebuffer) {
+	f.file.framebuffer = fb
+}
+
+// ReadScanlines reads the remaining chunks of the file in order, storing each in the framebuffer and then
+// calling fn with its first and last scanline.  The next chunk starts at y2+1, so fn may set a framebuffer
+// holding only the scanlines of the next chunk.  Reading stops at the first error from fn.
+func (f *StreamInputFile) ReadScanlines(fn func(y1, y2 int) error) error {
+	return f.ReadScanlinesContext(context.Background(), fn)
+}
+
+// ReadScanlinesContext is like ReadScanlines but stops between chunks once ctx is cancelled, returning
+// ctx.Err().
+func (f *StreamInputFile) ReadScanlinesContext(ctx context.Context, fn func(y1, y2 int) error) error {
+	h := &f.file.header
+
+	for k := range h.channels {
+		ch := &h.channels[k]
+
+		if pixels := f.file.framebuffer.find(ch.Name); pixels != nil {
+			if err := pixels.checkSampling(ch); err != nil {
+				return err
+			}
+		}
+	}
+
+	yMin, yMax := int(h.dataWindow[1]), int(h.dataWindow[3])
+	linesPerChunk := linesPerChunk(h.compression)
+
+	p := &progress{fn: f.file.progress, done: f.next, total: len(f.file.offsetTable)}
+
+	for ; f.next < len(f.file.offsetTable); f.next++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk := f.next
+		y := yMin + chunk*linesPerChunk
+		n := min(linesPerChunk, yMax-y+1)
+
+		var chunkHeader [8]byte
+
+		if _, err := io.ReadFull(f.r, chunkHeader[:]); err != nil {
+			return &ChunkError{chunk, fmt.Errorf("reading chunk header: %w", truncated(err))}
+		}
+
+		dataSize, size, err := f.file.checkChunkHeader(chunk, y, n, chunkHeader[:])
+
+		if err != nil {
+			return err
+		}
+
+		buf, err := readFull(f.r, dataSize)
+
+		if err != nil {
+			return &ChunkError{chunk, fmt.Errorf("reading pixel data: %w", truncated(err))}
+		}
+
+		data, err := decompressChunk(h.compression, buf, size)
+
+		if err != nil {
+			return &ChunkError{chunk, err}
+		}
+
+		if err := f.file.decodeLines(data, y, n, y, y+n-1); err != nil {
+			return err
+		}
+
+		if err := f.file.fillMissingChannels(y, y+n-1); err != nil {
+			return err
+		}
+
+		p.add()
+
+		if err := fn(y, y+n-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package exr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStreamOutputFile(t *testing.T) {
+	r, g, b := genImage()
+
+	hd := NewHeader(128, 128)
+	hd.SetCompression(CompressionTypeZip)
+	hd.AddChannel(Channel{Name: "B", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	hd.AddChannel(Channel{Name: "G", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	hd.AddChannel(Channel{Name: "R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "R", NewPlanarSlice(r, 0, 0, 128))
+	InsertSlice(&fb, "G", NewPlanarSlice(g, 0, 0, 128))
+	InsertSlice(&fb, "B", NewPlanarSlice(b, 0, 0, 128))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, hd)
+	of.SetFramebuffer(fb)
+	of.SetPreviewOptions(PreviewOptions{Width: 16})
+
+	if err := of.WritePixels(128); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	// Spool in memory and in a temporary file
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	for _, opts := range []*WriteOptions{nil, {MaxSpoolMemory: 100}} {
+		var out bytes.Buffer
+
+		sf := NewStreamOutputFile(&out, hd, opts)
+		sf.SetFramebuffer(fb)
+		sf.SetPreviewOptions(PreviewOptions{Width: 16})
+
+		if err := sf.WritePixels(100); err != nil {
+			t.Fatalf("error writing scanlines: %v", err)
+		}
+
+		if err := sf.WritePixels(28); err != nil {
+			t.Fatalf("error writing scanlines: %v", err)
+		}
+
+		if out.Len() != 0 {
+			t.Fatalf("expected nothing written before Close")
+		}
+
+		if err := sf.Close(); err != nil {
+			t.Fatalf("error closing file: %v", err)
+		}
+
+		if !bytes.Equal(out.Bytes(), ws.buf) {
+			t.Fatalf("%+v: streamed file doesn't match file written with seeking", opts)
+		}
+
+		if files, _ := os.ReadDir(tmp); len(files) != 0 {
+			t.Fatalf("spool file wasn't removed")
+		}
+	}
+
+	sf := NewStreamOutputFile(&bytes.Buffer{}, hd, &WriteOptions{MaxSpoolMemory: 100})
+	sf.SetFramebuffer(fb)
+
+	if err := sf.WritePixels(64); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if err := sf.Close(); err == nil {
+		t.Fatalf("expected error closing incomplete file")
+	}
+
+	if files, _ := os.ReadDir(tmp); len(files) != 0 {
+		t.Fatalf("spool file wasn't removed")
+	}
+}
+
+func TestStreamInputFile(t *testing.T) {
+	width, height := 8, 40
+
+	h := NewHeaderWindow(0, 10, int32(width-1), int32(10+height-1))
+	h.SetCompression(CompressionTypeZip)
+	h.AddChannel(Channel{Name: "Y", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+	data := make([]float32, width*height)
+
+	for i := range data {
+		data[i] = float32(i)
+	}
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "Y", NewPlanarSlice(data, 0, 10, width))
+
+	ws := &writeSeekBuffer{}
+	of := NewOutputFile(ws, h)
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	// io.MultiReader hides the Seek and ReadAt methods
+	in, err := NewStreamInputFile(io.MultiReader(bytes.NewReader(ws.buf)), nil)
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	out := make([]float32, width*height)
+	fill := make([]float32, width*height)
+
+	fb = Framebuffer{}
+	InsertSlice(&fb, "Y", NewPlanarSlice(out, 0, 10, width))
+
+	s := NewPlanarSlice(fill, 0, 10, width)
+	s.FillValue = 2
+	InsertSlice(&fb, "Z", s)
+
+	in.SetFramebuffer(fb)
+
+	var blocks [][2]int
+
+	if err := in.ReadScanlines(func(y1, y2 int) error {
+		blocks = append(blocks, [2]int{y1, y2})
+		return nil
+	}); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	if !reflect.DeepEqual(blocks, [][2]int{{10, 25}, {26, 41}, {42, 49}}) {
+		t.Fatalf("scanlines were read in blocks %v", blocks)
+	}
+
+	if !reflect.DeepEqual(out, data) {
+		t.Fatalf("streamed pixels don't match")
+	}
+
+	for _, v := range fill {
+		if v != 2 {
+			t.Fatalf("missing channel wasn't filled")
+		}
+	}
+
+	// A truncated stream stops at the cut chunk
+	in, err = NewStreamInputFile(bytes.NewReader(ws.buf[:of.offsetTable[2]+4]), nil)
+
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+
+	in.SetFramebuffer(fb)
+
+	n := 0
+	err = in.ReadScanlines(func(y1, y2 int) error {
+		n++
+		return nil
+	})
+
+	var chunkErr *ChunkError
+
+	if !errors.As(err, &chunkErr) || chunkErr.Chunk != 2 || !errors.Is(err, ErrTruncated) || n != 2 {
+		t.Fatalf("expected truncated chunk 2 after 2 chunks, got %v after %v", err, n)
+	}
+
+	h.SetLineOrder(LineOrderRandomY)
+	ws = &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, h).WritePixels(height); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if _, err := NewStreamInputFile(bytes.NewReader(ws.buf), nil); err == nil {
+		t.Fatalf("expected error streaming random line order")
+	}
+}
@@ -1,9 +1,11 @@
 package exr
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 type attrib struct {
@@ -35,6 +37,19 @@ type Box2i struct {
 	xMax, yMax int32
 }
 
+func (b *Box2i) UnmarshalBinary(data []byte) error {
+	if len(data) != 16 {
+		return fmt.Errorf("box2i has size %v, expected 16", len(data))
+	}
+
+	b.xMin = int32(binary.LittleEndian.Uint32(data[0:]))
+	b.yMin = int32(binary.LittleEndian.Uint32(data[4:]))
+	b.xMax = int32(binary.LittleEndian.Uint32(data[8:]))
+	b.yMax = int32(binary.LittleEndian.Uint32(data[12:]))
+
+	return nil
+}
+
 type Box2f struct {
 	xMin, yMin float32
 	xMax, yMax float32
@@ -47,6 +62,36 @@ type Chromaticities struct {
 	whiteX, whiteY float32
 }
 
+// NewChromaticities returns the CIE x,y coordinates of the given primaries and white point.
+func NewChromaticities(red, green, blue, white V2f) Chromaticities {
+	return Chromaticities{
+		redX: red[0], redY: red[1],
+		greenX: green[0], greenY: green[1],
+		blueX: blue[0], blueY: blue[1],
+		whiteX: white[0], whiteY: white[1],
+	}
+}
+
+// Rec709Chromaticities are the primaries and white point of ITU-R BT.709, which are assumed
+// when a file has no chromaticities attribute.
+var Rec709Chromaticities = NewChromaticities(V2f{0.6400, 0.3300}, V2f{0.3000, 0.6000}, V2f{0.1500, 0.0600}, V2f{0.3127, 0.3290})
+
+func (b *Chromaticities) UnmarshalBinary(data []byte) error {
+	if len(data) != 32 {
+		return fmt.Errorf("chromaticities has size %v, expected 32", len(data))
+	}
+
+	var v [8]float32
+
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+
+	*b = NewChromaticities(V2f{v[0], v[1]}, V2f{v[2], v[3]}, V2f{v[4], v[5]}, V2f{v[6], v[7]})
+
+	return nil
+}
+
 type Channel struct {
 	Name                 string
 	PixelType            int32
@@ -69,7 +114,6 @@ func (b Channel) MarshalBinary() (data []byte, err error) {
 	binary.Write(&buf, binary.LittleEndian, b.XSampling)
 	binary.Write(&buf, binary.LittleEndian, b.YSampling)
 
-	fmt.Printf("****MB: %v\n", buf.Bytes())
 	return buf.Bytes(), nil
 }
 
@@ -78,7 +122,6 @@ type Chlist []Channel
 func (b Chlist) MarshalBinary() ([]byte, error) {
 	buf := bytes.Buffer{}
 	for _, ch := range b {
-		fmt.Printf("**WRCH: %v\n", ch)
 		b, err := ch.MarshalBinary()
 
 		if err != nil {
@@ -93,6 +136,38 @@ func (b Chlist) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (b *Chlist) UnmarshalBinary(data []byte) error {
+	return b.unmarshalBinary(data, 0)
+}
+
+// unmarshalBinary decodes a channel list of at most maxChannels channels unless it is zero.
+func (b *Chlist) unmarshalBinary(data []byte, maxChannels int) error {
+	channels, err := readChlist(bufio.NewReader(bytes.NewReader(data)), maxChannels)
+
+	if err != nil {
+		return fmt.Errorf("Chlist.UnmarshalBinary: %v", err)
+	}
+
+	*b = (*b)[:0]
+
+	for _, ch := range channels {
+		c := Channel{
+			Name:      ch.name,
+			PixelType: int32(ch.pixelType),
+			XSampling: int32(ch.xSampling),
+			YSampling: int32(ch.ySampling),
+		}
+
+		if ch.pLinear {
+			c.PLinear = 1
+		}
+
+		*b = append(*b, c)
+	}
+
+	return nil
+}
+
 type Compression uint8
 type LineOrder uint8
 
@@ -106,13 +181,42 @@ type M33f [9]float32
 
 type M44f [16]float32
 
+// Preview is a small 8 bit RGBA version of the image, Data holds Width*Height pixels of 4 bytes each,
+// stored top to bottom.
 type Preview struct {
 	Width, Height uint32
 	Data          []byte
 }
 
-func (b *Preview) MarshalBinary() ([]byte, error) {
-	return nil, nil
+func (b Preview) MarshalBinary() ([]byte, error) {
+	if uint64(len(b.Data)) != uint64(b.Width)*uint64(b.Height)*4 {
+		return nil, fmt.Errorf("preview has %v bytes of data, expected %v", len(b.Data), uint64(b.Width)*uint64(b.Height)*4)
+	}
+
+	buf := make([]byte, 8, 8+len(b.Data))
+
+	binary.LittleEndian.PutUint32(buf[0:], b.Width)
+	binary.LittleEndian.PutUint32(buf[4:], b.Height)
+
+	return append(buf, b.Data...), nil
+}
+
+func (b *Preview) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("preview has size %v, expected at least 8", len(data))
+	}
+
+	width := binary.LittleEndian.Uint32(data[0:])
+	height := binary.LittleEndian.Uint32(data[4:])
+
+	if uint64(len(data)-8) != uint64(width)*uint64(height)*4 {
+		return fmt.Errorf("preview has %v bytes of data, expected %v", len(data)-8, uint64(width)*uint64(height)*4)
+	}
+
+	b.Width, b.Height = width, height
+	b.Data = append([]byte(nil), data[8:]...)
+
+	return nil
 }
 
 type Rational struct {
@@ -124,7 +228,7 @@ type Stringvector []string
 
 func (b Stringvector) MarshalBinary() ([]byte, error) {
 	buf := bytes.Buffer{}
-	for s := range b {
+	for _, s := range b {
 		v, err := String(s).MarshalBinary()
 
 		if err != nil {
@@ -136,6 +240,27 @@ func (b Stringvector) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (b *Stringvector) UnmarshalBinary(data []byte) error {
+	*b = (*b)[:0]
+
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("stringvector: truncated string length")
+		}
+
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+
+		if n < 0 || n > len(data)-4 {
+			return fmt.Errorf("stringvector: invalid string length %v", n)
+		}
+
+		*b = append(*b, string(data[4:4+n]))
+		data = data[4+n:]
+	}
+
+	return nil
+}
+
 type Timecode struct {
 	TimeAndFlags uint32
 	UserData     uint32
@@ -0,0 +1,83 @@
+package exr
+
+import (
+	"fmt"
+	"math"
+)
+
+// Window coordinates are limited so that widths and heights fit in an int32.
+const maxWindowCoordinate = math.MaxInt32 / 2
+
+// Validate checks that the header describes an image which can be written and read, following the
+// checks made by OpenEXR.  It is called by OutputFile before writing and by InputFile after reading a
+// header.  Multi-part files, which also need name and type attributes, are not supported by this package.
+func (h *Header) Validate() error {
+	for _, w := range []struct {
+		name   string
+		window [4]int32
+	}{{"display", h.displayWindow}, {"data", h.dataWindow}} {
+		xMin, yMin, xMax, yMax := w.window[0], w.window[1], w.window[2], w.window[3]
+
+		if xMin > xMax || yMin > yMax {
+			return fmt.Errorf("invalid %v window (%v, %v) - (%v, %v), minimum is greater than maximum", w.name, xMin, yMin, xMax, yMax)
+		}
+
+		for _, v := range w.window {
+			if v < -maxWindowCoordinate || v > maxWindowCoordinate {
+				return fmt.Errorf("invalid %v window (%v, %v) - (%v, %v), coordinates must be within +/-%v", w.name, xMin, yMin, xMax, yMax, maxWindowCoordinate)
+			}
+		}
+	}
+
+	if !(h.pixelAspectRatio >= 1e-6 && h.pixelAspectRatio <= 1e6) {
+		return fmt.Errorf("invalid pixel aspect ratio %v", h.pixelAspectRatio)
+	}
+
+	if !(h.screenWindowWidth >= 0) || math.IsInf(float64(h.screenWindowWidth), 0) {
+		return fmt.Errorf("invalid screen window width %v", h.screenWindowWidth)
+	}
+
+	if h.compression < CompressionTypeNone || h.compression > CompressionTypeB44A {
+		return fmt.Errorf("%w: invalid compression (%v)", ErrUnsupportedCompression, h.compression)
+	}
+
+	if h.lineOrder < LineOrderIncreasingY || h.lineOrder > LineOrderRandomY {
+		return fmt.Errorf("invalid line order (%v)", h.lineOrder)
+	}
+
+	if h.tiled {
+		td := h.tileDescription
+
+		if td.Width < 1 || td.Height < 1 || td.Width > maxWindowCoordinate || td.Height > maxWindowCoordinate {
+			return fmt.Errorf("invalid tile size %vx%v", td.Width, td.Height)
+		}
+
+		if td.Kind < TileOneLevel || td.Kind > TileRipMapLevels {
+			return fmt.Errorf("invalid tile level mode (%v)", td.Kind)
+		}
+	}
+
+	if len(h.channels) == 0 {
+		return fmt.Errorf("header has no channels")
+	}
+
+	for i, ch := range h.channels {
+		if ch.Name == "" {
+			return fmt.Errorf("channel %v has an empty name", i)
+		}
+
+		if i > 0 {
+			if prev := h.channels[i-1].Name; prev == ch.Name {
+				return fmt.Errorf("channel %v is in the header more than once", ch.Name)
+			} else if prev > ch.Name {
+				return fmt.Errorf("channels %v and %v are not in alphabetical order", prev, ch.Name)
+			}
+		}
+
+		if ch.PixelType < PixelTypeUInt || ch.PixelType > PixelTypeFloat {
+			return fmt.Errorf("invalid pixel type (%v) for channel %v", ch.PixelType, ch.Name)
+		}
+	}
+
+	return h.checkSampling()
+}
@@ -0,0 +1,71 @@
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() Header {
+		h := NewHeaderWindow(-2, -2, 5, 5)
+		h.AddChannel(Channel{Name: "G", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		h.AddChannel(Channel{Name: "R", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+		return h
+	}
+
+	h := valid()
+
+	if err := h.Validate(); err != nil {
+		t.Fatalf("expected valid header: %v", err)
+	}
+
+	for _, test := range []struct {
+		modify func(h *Header)
+		err    string
+	}{
+		{func(h *Header) { h.dataWindow[2] = -3 }, "invalid data window"},
+		{func(h *Header) { h.displayWindow[1] = -maxWindowCoordinate - 1 }, "invalid display window"},
+		{func(h *Header) { h.SetPixelAspectRatio(0) }, "invalid pixel aspect ratio"},
+		{func(h *Header) { h.SetScreenWindow(V2f{}, -1) }, "invalid screen window width"},
+		{func(h *Header) { h.SetCompression(42) }, "invalid compression"},
+		{func(h *Header) { h.SetTileDescription(TileDescription{Width: 0, Height: 16}) }, "invalid tile size"},
+		{func(h *Header) { h.channels = nil }, "no channels"},
+		{func(h *Header) { h.channels[1].Name = "G" }, "more than once"},
+		{func(h *Header) { h.channels[0].Name = "Z" }, "alphabetical order"},
+		{func(h *Header) { h.channels[0].PixelType = 3 }, "invalid pixel type"},
+		{func(h *Header) { h.dataWindow[0] = -1 }, "not a multiple of the sampling"},
+	} {
+		h := valid()
+		h.channels = append([]Channel(nil), h.channels...)
+		test.modify(&h)
+
+		if err := h.Validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+
+	if err := NewOutputFile(&writeSeekBuffer{}, NewHeader(2, 2)).WritePixels(2); err == nil {
+		t.Fatalf("expected error writing header without channels")
+	}
+
+	// Corrupt the pixel aspect ratio of a written file
+	ws := &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, h).WritePixels(8); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	i := bytes.Index(ws.buf, []byte("pixelAspectRatio\x00float\x00"))
+
+	if i < 0 {
+		t.Fatalf("pixelAspectRatio attribute not found")
+	}
+
+	binary.LittleEndian.PutUint32(ws.buf[i+len("pixelAspectRatio\x00float\x00")+4:], 0)
+
+	if _, err := NewInputFile(bytes.NewReader(ws.buf)); err == nil || !strings.Contains(err.Error(), "invalid pixel aspect ratio") {
+		t.Fatalf("expected error reading invalid pixel aspect ratio, got %v", err)
+	}
+}
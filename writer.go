@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 type Writer struct {
@@ -59,6 +60,10 @@ func attribType(v interface{}) string {
 		return "box2i"
 	case Chlist:
 		return "chlist"
+	case Chromaticities:
+		return "chromaticities"
+	case Preview:
+		return "preview"
 	case string:
 		return "string"
 	case String:
@@ -76,6 +81,10 @@ func WriteAttrib(attrib *EXRAttribute, w *bufio.Writer) error {
 		return nil
 	}
 
+	if len(attrib.name) > maxLongNameLength || len(attrib.attribType) > maxLongNameLength {
+		return fmt.Errorf("attribute %q (%v) name is longer than %v bytes", attrib.name, attrib.attribType, maxLongNameLength)
+	}
+
 	w.WriteString(attrib.name)
 	w.WriteByte(0x00)
 	w.WriteString(attrib.attribType)
@@ -95,3 +104,43 @@ func WriteAttrib(attrib *EXRAttribute, w *bufio.Writer) error {
 func WriteChlist(chlist []*EXRChannelInfo, w *bufio.Writer) error {
 	return nil
 }
+
+// writeSeekBuffer is an in-memory io.WriteSeeker used when the destination can't seek.
+type writeSeekBuffer struct {
+	buf []byte
+	pos int
+}
+
+func (b *writeSeekBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	}
+
+	copy(b.buf[b.pos:], p)
+	b.pos += len(p)
+
+	return len(p), nil
+}
+
+func (b *writeSeekBuffer) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = int64(b.pos) + offset
+	case io.SeekEnd:
+		pos = int64(len(b.buf)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence (%v)", whence)
+	}
+
+	if pos < 0 {
+		return 0, fmt.Errorf("seeking to negative position (%v)", pos)
+	}
+
+	b.pos = int(pos)
+
+	return pos, nil
+}
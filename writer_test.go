@@ -2,10 +2,14 @@ package exr
 
 import (
 	"bufio"
-	//"bytes"
+	"bytes"
+	"encoding/binary"
 	//"fmt"
+	"io"
 	"math"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -145,3 +149,350 @@ func TestWriter(t *testing.T) {
 		})
 	}*/
 }
+
+func TestWriterSubsampled(t *testing.T) {
+	y, ry, by := genImage()
+
+	// Chroma channels are stored at half resolution in both directions (4:2:0)
+	rySub := make([]float32, 64*64)
+	bySub := make([]float32, 64*64)
+
+	for j := 0; j < 64; j++ {
+		for i := 0; i < 64; i++ {
+			rySub[i+j*64] = ry[i*2+j*2*128]
+			bySub[i+j*64] = by[i*2+j*2*128]
+		}
+	}
+
+	hd := NewHeader(128, 128)
+	hd.SetCompression(CompressionTypeZip)
+	hd.AddChannel(Channel{Name: "Y", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+	hd.AddChannel(Channel{Name: "RY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+	hd.AddChannel(Channel{Name: "BY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+
+	f, err := os.Create("testdata/out3.exr")
+	defer f.Close()
+
+	if err != nil {
+		t.Fatalf("error creating testdata: %v", err)
+	}
+
+	of := NewOutputFile(f, hd)
+	fb := Framebuffer{}
+	fb.Insert("Y", Pixels{PixelTypeFloat, y, 0, 1, 128, 1, 1, 0})
+	fb.Insert("RY", Pixels{PixelTypeFloat, rySub, 0, 1, 64, 2, 2, 0})
+	fb.Insert("BY", Pixels{PixelTypeFloat, bySub, 0, 1, 64, 2, 2, 0})
+	of.SetFramebuffer(fb)
+
+	if err := of.WritePixels(128); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("error seeking testdata: %v", err)
+	}
+
+	in, err := NewInputFile(f)
+
+	if err != nil {
+		t.Fatalf("error reading testdata: %v", err)
+	}
+
+	yIn := make([]float32, 128*128)
+	ryIn := make([]float32, 64*64)
+	byIn := make([]float32, 64*64)
+
+	fb = Framebuffer{}
+	fb.Insert("Y", Pixels{PixelTypeFloat, yIn, 0, 1, 128, 1, 1, 0})
+	fb.Insert("RY", Pixels{PixelTypeFloat, ryIn, 0, 1, 64, 2, 2, 0})
+	fb.Insert("BY", Pixels{PixelTypeFloat, byIn, 0, 1, 64, 2, 2, 0})
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixels(0, 127); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	for i := range yIn {
+		if yIn[i] != Float16ToFloat32(Float32ToFloat16(y[i])) {
+			t.Fatalf("Y pixel %v: expected %v, got %v", i, y[i], yIn[i])
+		}
+	}
+
+	for i := range ryIn {
+		if ryIn[i] != Float16ToFloat32(Float32ToFloat16(rySub[i])) {
+			t.Fatalf("RY pixel %v: expected %v, got %v", i, rySub[i], ryIn[i])
+		}
+
+		if byIn[i] != Float16ToFloat32(Float32ToFloat16(bySub[i])) {
+			t.Fatalf("BY pixel %v: expected %v, got %v", i, bySub[i], byIn[i])
+		}
+	}
+}
+
+func TestWriterSubsampledWindow(t *testing.T) {
+	hd := NewHeaderWindow(1, 0, 128, 127)
+	hd.AddChannel(Channel{Name: "RY", PixelType: PixelTypeHalf, XSampling: 2, YSampling: 2})
+
+	if err := hd.checkSampling(); err == nil {
+		t.Fatalf("expected error for data window incompatible with sampling")
+	}
+}
+
+func TestWriterLongNames(t *testing.T) {
+	long := "beauty.diffuse.indirect.specular.R" // 34 bytes
+
+	hd := NewHeader(2, 2)
+	hd.AddChannel(Channel{Name: long, PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+
+	ws := &writeSeekBuffer{}
+
+	if err := NewOutputFile(ws, hd).WritePixels(2); err != nil {
+		t.Fatalf("error writing scanlines: %v", err)
+	}
+
+	version, err := ReadVersion(bufio.NewReader(bytes.NewReader(ws.buf)))
+
+	if err != nil {
+		t.Fatalf("error reading version: %v", err)
+	}
+
+	if !version.longName {
+		t.Fatalf("expected long name flag to be set")
+	}
+
+	in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	if h := in.Header(); h.FindChannel(long) == nil {
+		t.Fatalf("expected channel %v", long)
+	}
+
+	// Without the flag the name is too long
+	ws.buf[5] &^= 1 << 2
+
+	if _, err := NewInputFile(bytes.NewReader(ws.buf)); err == nil {
+		t.Fatalf("expected error reading long name without long name flag")
+	}
+
+	hd = NewHeader(2, 2)
+	hd.AddChannel(Channel{Name: strings.Repeat("x", 256), PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+
+	if err := NewOutputFile(&writeSeekBuffer{}, hd).WritePixels(2); err == nil {
+		t.Fatalf("expected error writing name longer than 255 bytes")
+	}
+}
+
+func TestWriterParallel(t *testing.T) {
+	r, g, b := genImage()
+
+	write := func(lineOrder int, opts *WriteOptions) []byte {
+		hd := NewHeader(128, 128)
+		hd.SetCompression(CompressionTypeZip)
+		hd.SetLineOrder(lineOrder)
+		hd.AddChannel(Channel{Name: "B", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		hd.AddChannel(Channel{Name: "G", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		hd.AddChannel(Channel{Name: "R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+		var fb Framebuffer
+
+		InsertSlice(&fb, "R", NewPlanarSlice(r, 0, 0, 128))
+		InsertSlice(&fb, "G", NewPlanarSlice(g, 0, 0, 128))
+		InsertSlice(&fb, "B", NewPlanarSlice(b, 0, 0, 128))
+
+		ws := &writeSeekBuffer{}
+		of := NewOutputFileOptions(ws, hd, opts)
+		of.SetFramebuffer(fb)
+
+		// Blocks which don't line up with the chunks
+		for y := 0; y < 128; y += 40 {
+			if err := of.WritePixels(min(40, 128-y)); err != nil {
+				t.Fatalf("error writing scanlines: %v", err)
+			}
+		}
+
+		return ws.buf
+	}
+
+	want := write(LineOrderIncreasingY, &WriteOptions{Workers: 1})
+
+	for _, opts := range []*WriteOptions{nil, {Workers: 4, ChunksInFlight: 1}, {Workers: 3, ChunksInFlight: 5}} {
+		if got := write(LineOrderIncreasingY, opts); !bytes.Equal(got, want) {
+			t.Fatalf("%+v: file doesn't match file written serially", opts)
+		}
+	}
+
+	// Random line order files may have their chunks in any order
+	in, err := NewInputFile(bytes.NewReader(write(LineOrderRandomY, &WriteOptions{Workers: 4})))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	if h := in.Header(); h.LineOrder() != LineOrderRandomY {
+		t.Fatalf("expected random line order, got %v", h.LineOrder())
+	}
+
+	out := make([]float32, 128*128)
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "R", NewPlanarSlice(out, 0, 0, 128))
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixels(0, 127); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	for i := range out {
+		if out[i] != r[i] {
+			t.Fatalf("pixel %v is %v, expected %v", i, out[i], r[i])
+		}
+	}
+}
+
+func TestWriterLineOrder(t *testing.T) {
+	for _, lineOrder := range []int{LineOrderIncreasingY, LineOrderDecreasingY, LineOrderRandomY} {
+		hd := NewHeader(8, 4)
+		hd.SetLineOrder(lineOrder)
+		hd.AddChannel(Channel{Name: "R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+		var fb Framebuffer
+
+		InsertSlice(&fb, "R", NewPlanarSlice(make([]float32, 8*4), 0, 0, 8))
+
+		ws := &writeSeekBuffer{}
+		of := NewOutputFileOptions(ws, hd, &WriteOptions{Workers: 4})
+		of.SetFramebuffer(fb)
+
+		err := of.WritePixels(4)
+
+		if lineOrder == LineOrderDecreasingY {
+			if err == nil {
+				t.Fatalf("expected error writing decreasing y line order")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("line order %v: error writing scanlines: %v", lineOrder, err)
+		}
+
+		in, err := NewInputFile(bytes.NewReader(ws.buf))
+
+		if err != nil {
+			t.Fatalf("line order %v: error reading file: %v", lineOrder, err)
+		}
+
+		// The y of each chunk in the order they're stored
+		offsets := append([]uint64(nil), in.offsetTable...)
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		seen := make(map[int32]bool)
+		var stored []int32
+
+		for _, ofs := range offsets {
+			y := int32(binary.LittleEndian.Uint32(ws.buf[ofs:]))
+			seen[y] = true
+			stored = append(stored, y)
+		}
+
+		if len(seen) != 4 {
+			t.Fatalf("line order %v: expected chunks for 4 scanlines, got %v", lineOrder, stored)
+		}
+
+		if lineOrder == LineOrderIncreasingY {
+			for i, y := range stored {
+				if y != int32(i) {
+					t.Fatalf("expected chunks stored in increasing y, got %v", stored)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	r, g, b := genImage()
+
+	write := func(lineOrder int, concurrent bool) []byte {
+		hd := NewHeader(128, 128)
+		hd.SetCompression(CompressionTypeZip)
+		hd.SetLineOrder(lineOrder)
+		hd.AddChannel(Channel{Name: "B", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		hd.AddChannel(Channel{Name: "G", PixelType: PixelTypeHalf, XSampling: 1, YSampling: 1})
+		hd.AddChannel(Channel{Name: "R", PixelType: PixelTypeFloat, XSampling: 1, YSampling: 1})
+
+		var fb Framebuffer
+
+		InsertSlice(&fb, "R", NewPlanarSlice(r, 0, 0, 128))
+		InsertSlice(&fb, "G", NewPlanarSlice(g, 0, 0, 128))
+		InsertSlice(&fb, "B", NewPlanarSlice(b, 0, 0, 128))
+
+		ws := &writeSeekBuffer{}
+		of := NewOutputFile(ws, hd)
+		of.SetFramebuffer(fb)
+
+		if !concurrent {
+			if err := of.WritePixels(128); err != nil {
+				t.Fatalf("error writing scanlines: %v", err)
+			}
+
+			return ws.buf
+		}
+
+		// Blocks of 2 chunks written from the bottom up
+		errs := make(chan error, 4)
+
+		for y := 96; y >= 0; y -= 32 {
+			go func(y int) {
+				errs <- of.WriteScanlines(y, y+31)
+			}(y)
+		}
+
+		for i := 0; i < 4; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("error writing scanlines: %v", err)
+			}
+		}
+
+		if err := of.WriteScanlines(0, 15); err == nil {
+			t.Fatalf("expected error writing chunk twice")
+		}
+
+		if err := of.WriteScanlines(8, 23); err == nil {
+			t.Fatalf("expected error writing part of a chunk")
+		}
+
+		return ws.buf
+	}
+
+	if !bytes.Equal(write(LineOrderIncreasingY, true), write(LineOrderIncreasingY, false)) {
+		t.Fatalf("file written concurrently doesn't match file written serially")
+	}
+
+	in, err := NewInputFile(bytes.NewReader(write(LineOrderRandomY, true)))
+
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+
+	out := make([]float32, 128*128)
+
+	var fb Framebuffer
+
+	InsertSlice(&fb, "R", NewPlanarSlice(out, 0, 0, 128))
+	in.SetFramebuffer(fb)
+
+	if err := in.ReadPixels(0, 127); err != nil {
+		t.Fatalf("error reading scanlines: %v", err)
+	}
+
+	for i := range out {
+		if out[i] != r[i] {
+			t.Fatalf("pixel %v is %v, expected %v", i, out[i], r[i])
+		}
+	}
+}
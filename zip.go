@@ -0,0 +1,57 @@
+package exr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// zipEncode will apply the EXR specific preprocess and then zlib compress the buffer.
+// It will return either the compressed buffer or the original buffer depending on which is smaller.
+func zipEncode(buf []byte) ([]byte, error) {
+	tmpBuf := predictorEncode(buf)
+
+	out := bytes.Buffer{}
+
+	zw := zlib.NewWriter(&out)
+
+	if _, err := zw.Write(tmpBuf); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	if out.Len() < len(buf) {
+		return out.Bytes(), nil
+	}
+
+	return buf, nil
+}
+
+// zipDecode will zlib decompress the buffer, which must expand to at most maxSize bytes, and reverse the
+// EXR specific preprocess.
+func zipDecode(buf []byte, maxSize int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(buf))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer zr.Close()
+
+	// Read one byte more than allowed to detect data which expands too far
+	tmpBuf, err := io.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tmpBuf) > maxSize {
+		return nil, fmt.Errorf("zip: decompressed data is larger than %v bytes", maxSize)
+	}
+
+	return predictorDecode(tmpBuf), nil
+}
@@ -0,0 +1,49 @@
+package exr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestZIPEncodeDecode(t *testing.T) {
+	tc := bytes.Repeat([]byte("1011011011011012022022022222222222222220000001011011013233233240000000003233232323322323323"), 8)
+
+	out, err := zipEncode(tc)
+
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+
+	decomp, err := zipDecode(out, len(tc))
+
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+
+	if !bytes.Equal(tc, decomp) {
+		t.Fatalf("decompressed != compressed, expected %v, got %v", tc, decomp)
+	}
+
+	if _, err := zipDecode(out, len(tc)-1); err == nil {
+		t.Fatalf("expected error decoding more than the limit")
+	}
+}
+
+func FuzzZIPDecode(f *testing.F) {
+	seed, _ := zipEncode(bytes.Repeat([]byte{1, 2, 3, 4}, 64))
+
+	f.Add(seed, 256)
+	f.Add([]byte{0x78, 0x9c}, 16)
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize int) {
+		if maxSize < 0 || maxSize > 1<<16 {
+			return
+		}
+
+		out, err := zipDecode(data, maxSize)
+
+		if err == nil && len(out) > maxSize {
+			t.Fatalf("decoded %v bytes, limit was %v", len(out), maxSize)
+		}
+	})
+}